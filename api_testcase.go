@@ -51,6 +51,10 @@ type Case struct {
 
 	standardHeader          map[string]any // can be string or []string
 	standardHeaderFromStore map[string]string
+	standardAuth            *api.RequestAuth
+	authTokens              *api.AuthTokenCache
+	standardResolve         map[string]string
+	standardProxy           string
 
 	ServerURL         string `json:"server_url"`
 	ReverseTestResult bool   `json:"reverse_test_result"`
@@ -281,6 +285,7 @@ func (testCase Case) executeRequest(counter int) (responsesMatch compare.Compare
 		return responsesMatch, req, apiResp, err
 	}
 
+	req.ResponseFormat = expRes.Format
 	apiResp, err = req.Send()
 	if err != nil {
 		testCase.logReq(req)
@@ -513,8 +518,11 @@ func (testCase Case) loadExpectedResponse() (res api.Response, err error) {
 
 func (testCase Case) responsesEqual(expected, got api.Response) (comp compare.CompareResult, err error) {
 	if expected.StatusCode == nil {
-		// if the statuscode is not set, use the default status code 200
-		expected.StatusCode = new(200)
+		// if the statuscode is not set, use the default status code 200,
+		// unless the request failed with the expected transport error
+		if got.Error == nil {
+			expected.StatusCode = new(200)
+		}
 	} else {
 		// if the statuscode is set to 0,
 		// remove the statuscode key from the expected response to accept any response code
@@ -568,6 +576,15 @@ func (testCase Case) loadRequestSerialization() (req api.Request, err error) {
 	spec.ManifestDir = testCase.manifestDir
 	spec.DataStore = testCase.dataStore
 	spec.CookieJar = testCase.cookieJar
+	spec.AuthTokens = testCase.authTokens
+	// raw requests are sent literally and cookie_jar requests are not sent,
+	// so the default auth is not used for them
+	if spec.Auth == nil && spec.Raw == nil && spec.Jar == nil {
+		spec.Auth = testCase.standardAuth
+	}
+	if spec.Proxy == "" {
+		spec.Proxy = testCase.standardProxy
+	}
 
 	if spec.ServerURL == "" {
 		spec.ServerURL = testCase.ServerURL
@@ -582,6 +599,16 @@ func (testCase Case) loadRequestSerialization() (req api.Request, err error) {
 		}
 	}
 
+	if len(spec.Resolve) == 0 && len(testCase.standardResolve) > 0 {
+		spec.Resolve = make(map[string]string)
+	}
+	for k, v := range testCase.standardResolve {
+		_, exist := spec.Resolve[k]
+		if !exist {
+			spec.Resolve[k] = v
+		}
+	}
+
 	if len(spec.HeaderFromStore) == 0 {
 		spec.HeaderFromStore = make(map[string]string)
 	}
@@ -612,6 +639,9 @@ func (testCase Case) loadResponseSerialization(genJSON any) (spec api.ResponseSe
 		return spec, fmt.Errorf("unmarshaling response: %w", err)
 	}
 
+	// paths in the response format are relative to the manifest
+	spec.Format.ManifestDir = testCase.manifestDir
+
 	// the body must not be parsed if it is not expected in the response, or should not be stored
 	if spec.Body == nil && len(testCase.StoreResponse) == 0 {
 		spec.Format.IgnoreBody = true
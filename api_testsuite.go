@@ -20,6 +20,7 @@ import (
 
 	"github.com/programmfabrik/apitest/internal/httpproxy"
 	"github.com/programmfabrik/apitest/internal/smtp"
+	"github.com/programmfabrik/apitest/pkg/lib/api"
 	"github.com/programmfabrik/apitest/pkg/lib/datastore"
 	"github.com/programmfabrik/apitest/pkg/lib/filesystem"
 	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
@@ -47,6 +48,8 @@ type Suite struct {
 
 	StandardHeader          map[string]any    `yaml:"header" json:"header"`
 	StandardHeaderFromStore map[string]string `yaml:"header_from_store" json:"header_from_store"`
+	// StandardAuth is used for all requests without their own "auth"
+	StandardAuth *api.RequestAuth `yaml:"auth" json:"auth"`
 
 	// CookieJar opts the whole suite into a shared cookie jar: Set-Cookie
 	// responses are stored and replayed honoring Path/Domain/Secure like a
@@ -55,6 +58,7 @@ type Suite struct {
 
 	config          testToolConfig
 	cookieJar       http.CookieJar
+	authTokens      *api.AuthTokenCache
 	datastore       *datastore.Datastore
 	manifestRelDir  string
 	manifestDir     string
@@ -169,6 +173,9 @@ func newTestSuite(
 		}
 	}
 
+	// oauth2 tokens are requested once per suite and reused until they expire
+	suite.authTokens = api.NewAuthTokenCache(suite.config.oAuthClient)
+
 	// Append suite manifest path to name, so we know in an automatic setup where the test is loaded from
 	suite.Name = fmt.Sprintf("%s (%s)", suite.Name, manifestPath)
 
@@ -202,6 +209,9 @@ func (ats *Suite) run() bool {
 	ats.startHttpServer()
 	defer ats.stopHttpServer()
 
+	defer api.CloseTransports()
+	defer api.RemoveKeptFiles()
+
 	err := os.Chdir(ats.manifestDir)
 	if err != nil {
 		logrus.Fatalf("Unable to switch working directory to %q", ats.manifestDir)
@@ -444,6 +454,10 @@ func (ats *Suite) runLiteralTest(
 	test.cookieJar = ats.cookieJar
 	test.standardHeader = ats.StandardHeader
 	test.standardHeaderFromStore = ats.StandardHeaderFromStore
+	test.standardAuth = ats.StandardAuth
+	test.authTokens = ats.authTokens
+	test.standardResolve = ats.config.resolve
+	test.standardProxy = ats.config.proxy
 	if test.LogNetwork == nil {
 		test.LogNetwork = &ats.config.logNetwork
 	}
@@ -31,6 +31,9 @@ type configStruct struct {
 			Format string `mapstructure:"format"`
 		} `mapstructure:"report"`
 		OAuthClient util.OAuthClientsConfig `mapstructure:"oauth_client"`
+		Proxy       string                  `mapstructure:"proxy"`
+		// Resolve is read in loadConfig, the host keys contain dots
+		Resolve map[string]string `mapstructure:"-"`
 	}
 }
 
@@ -54,6 +57,8 @@ func loadConfig(cfgFile string) {
 	}
 
 	viper.Unmarshal(&Config)
+	// viper splits keys at the dots, so the hosts would become nested maps
+	Config.Apitest.Resolve = viper.GetStringMapString("apitest.resolve")
 }
 
 // testToolConfig gives us the basic testtool infos
@@ -65,6 +70,8 @@ type testToolConfig struct {
 	logVerbose      bool
 	logShort        bool
 	oAuthClient     util.OAuthClientsConfig
+	resolve         map[string]string
+	proxy           string
 }
 
 // newTestToolConfig is mostly used for testing purpose. We can setup our config with this function
@@ -76,6 +83,8 @@ func newTestToolConfig(serverURL string, rootDirectory []string, logNetwork bool
 		logVerbose:     logVerbose,
 		logShort:       logShort,
 		oAuthClient:    Config.Apitest.OAuthClient,
+		resolve:        Config.Apitest.Resolve,
+		proxy:          Config.Apitest.Proxy,
 	}
 
 	config.fillInOAuthClientNames()
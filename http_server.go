@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"unicode/utf8"
 
 	"github.com/pkg/errors"
@@ -46,6 +47,9 @@ func (ats *Suite) startHttpServer() {
 	// bounce query response with query in response body, as it is
 	mux.Handle("/bounce-query", logH(ats.config.logShort, http.HandlerFunc(bounceQuery)))
 
+	// redirect to the location in the query
+	mux.Handle("/redirect", logH(ats.config.logShort, cookiesMiddleware(http.HandlerFunc(redirect))))
+
 	// Start listening into proxy
 	ats.httpServerProxy = httpproxy.New(ats.HttpServer.Proxy)
 	ats.httpServerProxy.RegisterRoutes(mux, "/", ats.config.logShort)
@@ -95,6 +99,17 @@ func customStaticHandler(h http.Handler) http.HandlerFunc {
 		if noContentLengthHeader == "1" || noContentLengthHeader == "true" {
 			w.Header().Set("Content-Encoding", "identity")
 		}
+		// Serve precompressed files with the given Content-Encoding header
+		contentEncoding := qs.Get("content-encoding")
+		if contentEncoding != "" {
+			w.Header().Set("Content-Encoding", contentEncoding)
+		}
+		// Serve files with the given Content-Type header instead of the
+		// one detected from the file extension
+		contentType := qs.Get("content-type")
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
 		h.ServeHTTP(w, r)
 	}
 }
@@ -208,9 +223,31 @@ func bounceBinary(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// the body is returned as is, so it still has the encoding of the request
+	contentEncoding := r.Header.Get("Content-Encoding")
+	if contentEncoding != "" {
+		w.Header().Set("Content-Encoding", contentEncoding)
+	}
+
 	io.Copy(w, r.Body)
 }
 
+// redirect redirects to the "location" from the query, with the "status"
+// from the query (default 302)
+func redirect(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	status := http.StatusFound
+	if qs.Get("status") != "" {
+		var err error
+		status, err = strconv.Atoi(qs.Get("status"))
+		if err != nil || status < 300 || status > 399 {
+			formatErrorResponse(w, http.StatusBadRequest, errors.Errorf("invalid redirect status %q", qs.Get("status")), nil)
+			return
+		}
+	}
+	http.Redirect(w, r, qs.Get("location"), status)
+}
+
 // bounceQuery returns the request query in response body
 // for those cases where a body cannt be provided
 func bounceQuery(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+)
+
+type responseFormatArchive struct {
+	// Parse converts the content of the entries whose path matches the
+	// pattern (see path.Match) with the format, the result is in "content".
+	// If more than one pattern matches, the first in sort order is used.
+	Parse map[string]ResponseFormat `json:"parse,omitempty"`
+}
+
+// archiveEntry is an entry of a zip or tar archive
+type archiveEntry struct {
+	Path    string `json:"path"`
+	Type    string `json:"type"` // "file", "dir", "symlink", "link" (tar hard link) or "other"
+	Size    int64  `json:"size"`
+	Mode    string `json:"mode"`             // permission bits in octal, like "0644"
+	MTime   string `json:"mtime"`            // RFC 3339
+	SHA256  string `json:"sha256,omitempty"` // only for files
+	Link    string `json:"link,omitempty"`   // target of symlinks and links
+	Content any    `json:"content,omitempty"`
+}
+
+// tarCompressions are detected by their magic bytes
+var tarCompressions = []struct {
+	magic  []byte
+	coding string
+}{
+	{[]byte{0x1f, 0x8b}, "gzip"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"},
+}
+
+func newArchiveEntry(name string, mode fs.FileMode, mtime time.Time) (entry archiveEntry) {
+	entry = archiveEntry{
+		Path:  strings.TrimPrefix(name, "./"),
+		Type:  "other",
+		Mode:  fmt.Sprintf("%04o", mode.Perm()),
+		MTime: mtime.UTC().Format(time.RFC3339),
+	}
+	switch {
+	case mode.IsRegular():
+		entry.Type = "file"
+	case mode.IsDir():
+		entry.Type = "dir"
+	case mode&fs.ModeSymlink != 0:
+		entry.Type = "symlink"
+	}
+	return entry
+}
+
+// setContent hashes the content of a file and parses it, if a format
+// matches the path
+func (af responseFormatArchive) setContent(entry *archiveEntry, content io.Reader, manifestDir string) (err error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", entry.Path, err)
+	}
+	entry.Size = int64(len(data))
+	sum := sha256.Sum256(data)
+	entry.SHA256 = hex.EncodeToString(sum[:])
+
+	for _, pattern := range slices.Sorted(maps.Keys(af.Parse)) {
+		matched, err := path.Match(pattern, entry.Path)
+		if err != nil {
+			return fmt.Errorf("parse pattern %q: %w", pattern, err)
+		}
+		if !matched {
+			continue
+		}
+		format := af.Parse[pattern]
+		format.ManifestDir = manifestDir
+		entryResp := Response{StatusCode: new(0), Body: data}
+		entry.Content, err = entryResp.ServerResponseToGenericJSON(format, true)
+		if err != nil {
+			return fmt.Errorf("parsing %q: %w", entry.Path, err)
+		}
+		break
+	}
+	return nil
+}
+
+// zip2Json lists the entries of the zip archive
+func zip2Json(raw []byte, af responseFormatArchive, manifestDir string) (jsonStr []byte, err error) {
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("opening zip: %w", err)
+	}
+
+	entries := []archiveEntry{}
+	for _, f := range zr.File {
+		entry := newArchiveEntry(f.Name, f.Mode(), f.Modified)
+		if entry.Type == "file" || entry.Type == "symlink" {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("opening %q: %w", f.Name, err)
+			}
+			if entry.Type == "symlink" {
+				// the target is the content of the entry
+				target, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					return nil, fmt.Errorf("reading %q: %w", f.Name, err)
+				}
+				entry.Link = string(target)
+			} else {
+				err = af.setContent(&entry, rc, manifestDir)
+				rc.Close()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return jsutil.Marshal(entries)
+}
+
+// tar2Json lists the entries of the tar archive, which can be compressed
+// with gzip or zstd
+func tar2Json(raw []byte, af responseFormatArchive, manifestDir string) (jsonStr []byte, err error) {
+	var r io.Reader = bytes.NewReader(raw)
+	for _, c := range tarCompressions {
+		if bytes.HasPrefix(raw, c.magic) {
+			r, err = contentDecoder(c.coding, r)
+			if err != nil {
+				return nil, fmt.Errorf("decompressing tar: %w", err)
+			}
+			break
+		}
+	}
+
+	entries := []archiveEntry{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader || hdr.Name == "./" || hdr.Name == "." {
+			continue
+		}
+		entry := newArchiveEntry(hdr.Name, hdr.FileInfo().Mode(), hdr.ModTime)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			err = af.setContent(&entry, tr, manifestDir)
+			if err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			entry.Link = hdr.Linkname
+		case tar.TypeLink:
+			entry.Type = "link"
+			entry.Link = hdr.Linkname
+		}
+		entries = append(entries, entry)
+	}
+
+	return jsutil.Marshal(entries)
+}
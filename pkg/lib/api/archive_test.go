@@ -0,0 +1,136 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/tidwall/gjson"
+)
+
+var archiveTestMTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func archiveTestFiles() map[string]string {
+	return map[string]string{
+		"export/data.json":  `{"id":1,"name":"apitest"}`,
+		"export/report.csv": "id,name\n1,apitest\n",
+	}
+}
+
+func TestArchive_Zip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	_, err := zw.CreateHeader(&zip.FileHeader{Name: "export/", Modified: archiveTestMTime})
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	for _, name := range []string{"export/data.json", "export/report.csv"} {
+		fh := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archiveTestMTime}
+		fh.SetMode(0640)
+		w, err := zw.CreateHeader(fh)
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		w.Write([]byte(archiveTestFiles()[name]))
+	}
+	err = zw.Close()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	response := Response{
+		StatusCode: new(http.StatusOK),
+		Body:       buf.Bytes(),
+		Format: ResponseFormat{
+			Type: responseTypeZip,
+			Archive: responseFormatArchive{
+				Parse: map[string]ResponseFormat{
+					"export/*.json": {},
+					"*/report.csv":  {Type: responseTypeCsv},
+				},
+			},
+		},
+	}
+	jsonStr, err := response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	sum := sha256.Sum256([]byte(archiveTestFiles()["export/data.json"]))
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "#").String(), "3")
+	for path, exp := range map[string]string{
+		"0.path":           "export/",
+		"0.type":           "dir",
+		"0.sha256":         "",
+		"1.path":           "export/data.json",
+		"1.type":           "file",
+		"1.size":           "25",
+		"1.mode":           "0640",
+		"1.mtime":          "2024-05-01T12:00:00Z",
+		"1.sha256":         hex.EncodeToString(sum[:]),
+		"1.content.name":   "apitest",
+		"2.content.0.id":   "1",
+		"2.content.0.name": "apitest",
+		"2.content.#":      "1",
+	} {
+		go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, path).String(), exp)
+	}
+
+	response.Body = []byte("no zip")
+	_, err = response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectError(t, err, "ServerResponseToJsonString did not fail on invalid zip")
+
+	response.Body = buf.Bytes()
+	response.Format.Archive.Parse = map[string]ResponseFormat{"export/report.csv": {}}
+	_, err = response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectError(t, err, "ServerResponseToJsonString did not fail on csv parsed as json")
+}
+
+func TestArchive_TarGz(t *testing.T) {
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, hdr := range []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0755, ModTime: archiveTestMTime},
+		{Name: "./export/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: archiveTestMTime},
+		{Name: "./export/data.json", Typeflag: tar.TypeReg, Mode: 0644, ModTime: archiveTestMTime, Size: int64(len(archiveTestFiles()["export/data.json"]))},
+		{Name: "./latest.json", Typeflag: tar.TypeSymlink, Linkname: "export/data.json", Mode: 0777, ModTime: archiveTestMTime},
+	} {
+		err := tw.WriteHeader(hdr)
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(archiveTestFiles()["export/data.json"]))
+		}
+	}
+	tw.Close()
+	gw.Close()
+
+	response := Response{
+		StatusCode: new(http.StatusOK),
+		Body:       buf.Bytes(),
+		Format: ResponseFormat{
+			Type: responseTypeTar,
+			Archive: responseFormatArchive{
+				Parse: map[string]ResponseFormat{
+					"export/data.json": {Type: responseTypeBinary},
+				},
+			},
+		},
+	}
+	jsonStr, err := response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "#").String(), "3")
+	for path, exp := range map[string]string{
+		"0.path":              "export/",
+		"0.type":              "dir",
+		"0.mode":              "0755",
+		"1.path":              "export/data.json",
+		"1.size":              "25",
+		"1.content.mime_type": "application/json",
+		"2.path":              "latest.json",
+		"2.type":              "symlink",
+		"2.link":              "export/data.json",
+	} {
+		go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, path).String(), exp)
+	}
+}
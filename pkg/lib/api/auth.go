@@ -0,0 +1,563 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/datastore"
+	"github.com/programmfabrik/apitest/pkg/lib/util"
+	"golang.org/x/oauth2"
+)
+
+const (
+	authTypeNone     string = "none"
+	authTypeBasic    string = "basic"
+	authTypeBearer   string = "bearer"
+	authTypeDigest   string = "digest"
+	authTypeOAuth2   string = "oauth2"
+	authTypeHMAC     string = "hmac"
+	authTypeAWSSigV4 string = "aws_sigv4"
+)
+
+// RequestAuth authenticates the request. It is applied in buildHttpRequest
+// after all headers and the body are final. "digest" needs the challenge of
+// the server and is answered in Send.
+type RequestAuth struct {
+	Type     string              `yaml:"type" json:"type"`           // "none", "basic", "bearer", "digest", "oauth2", "hmac", "aws_sigv4"
+	Basic    requestAuthUser     `yaml:"basic" json:"basic"`         // ignored if type != "basic"
+	Bearer   requestAuthBearer   `yaml:"bearer" json:"bearer"`       // ignored if type != "bearer"
+	Digest   requestAuthUser     `yaml:"digest" json:"digest"`       // ignored if type != "digest"
+	OAuth2   requestAuthOAuth2   `yaml:"oauth2" json:"oauth2"`       // ignored if type != "oauth2"
+	HMAC     requestAuthHMAC     `yaml:"hmac" json:"hmac"`           // ignored if type != "hmac"
+	AWSSigV4 requestAuthAWSSigV4 `yaml:"aws_sigv4" json:"aws_sigv4"` // ignored if type != "aws_sigv4"
+}
+
+type requestAuthUser struct {
+	Username          string `yaml:"username" json:"username"`
+	UsernameFromStore string `yaml:"username_from_store" json:"username_from_store"`
+	Password          string `yaml:"password" json:"password"`
+	PasswordFromStore string `yaml:"password_from_store" json:"password_from_store"`
+}
+
+func (cfg requestAuthUser) credentials(ds *datastore.Datastore) (username, password string, err error) {
+	username, err = authValue(cfg.Username, cfg.UsernameFromStore, ds)
+	if err != nil {
+		return "", "", err
+	}
+	password, err = authValue(cfg.Password, cfg.PasswordFromStore, ds)
+	if err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+type requestAuthBearer struct {
+	Token          string `yaml:"token" json:"token"`
+	TokenFromStore string `yaml:"token_from_store" json:"token_from_store"`
+}
+
+type requestAuthOAuth2 struct {
+	Client          string           `yaml:"client" json:"client"` // name of the client in the oauth_client config
+	Grant           string           `yaml:"grant" json:"grant"`   // "client_credentials" (default), "password"
+	requestAuthUser `yaml:",inline"` // user for grant "password"
+}
+
+type requestAuthHMAC struct {
+	Algorithm        string   `yaml:"algorithm" json:"algorithm"` // "sha256" (default), "sha1", "sha512", "md5"
+	Key              string   `yaml:"key" json:"key"`
+	KeyFromStore     string   `yaml:"key_from_store" json:"key_from_store"`
+	Header           string   `yaml:"header" json:"header"` // header for the signature, default "X-Signature"
+	Prefix           string   `yaml:"prefix" json:"prefix"` // prepended to the signature in the header
+	SignedHeaders    []string `yaml:"signed_headers" json:"signed_headers"`
+	Canonicalization string   `yaml:"canonicalization" json:"canonicalization"` // "request" (default), "body"
+	Encoding         string   `yaml:"encoding" json:"encoding"`                 // "hex" (default), "base64"
+}
+
+type requestAuthAWSSigV4 struct {
+	Region                   string `yaml:"region" json:"region"`
+	Service                  string `yaml:"service" json:"service"`
+	AccessKeyID              string `yaml:"access_key_id" json:"access_key_id"`
+	AccessKeyIDFromStore     string `yaml:"access_key_id_from_store" json:"access_key_id_from_store"`
+	SecretAccessKey          string `yaml:"secret_access_key" json:"secret_access_key"`
+	SecretAccessKeyFromStore string `yaml:"secret_access_key_from_store" json:"secret_access_key_from_store"`
+	SessionToken             string `yaml:"session_token" json:"session_token"`
+	SessionTokenFromStore    string `yaml:"session_token_from_store" json:"session_token_from_store"`
+}
+
+// authValue returns the value, or the value from the datastore if
+// storeKey is set
+func authValue(value, storeKey string, ds *datastore.Datastore) (v string, err error) {
+	if storeKey == "" {
+		return value, nil
+	}
+	if ds == nil {
+		return "", fmt.Errorf("can't get %q from store as the datastore is nil", storeKey)
+	}
+	vAny, err := ds.Get(storeKey)
+	if err != nil {
+		return "", fmt.Errorf("could not get %q from Datastore: %w", storeKey, err)
+	}
+	return util.GetStringFromInterface(vAny)
+}
+
+// requestHost returns the host the request is sent to
+func requestHost(req *http.Request) (host string) {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// readRequestBody reads the body of the request and replaces it, so that
+// the request can still be sent
+func readRequestBody(req *http.Request) (body []byte, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return []byte{}, nil
+	}
+	body, err = io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	req.Body.Close()
+
+	req.ContentLength = int64(len(body))
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+// requestBodyStream is a request body which is streamed (body_type "file"
+// or "generate"), it is not read into memory to sign it
+type requestBodyStream struct {
+	// reopen returns a new reader of the body to hash it, it is nil if the
+	// body can't be read twice
+	reopen func() (body io.ReadCloser, err error)
+}
+
+// hashRequestBody writes the body into h. A streamed body is read from
+// stream.reopen, the body of the request is still streamed when it is sent.
+func hashRequestBody(req *http.Request, stream *requestBodyStream, h io.Writer) (err error) {
+	if stream == nil {
+		body, err := readRequestBody(req)
+		if err != nil {
+			return err
+		}
+		_, _ = h.Write(body)
+		return nil
+	}
+	if stream.reopen == nil {
+		return fmt.Errorf("the body can't be read twice to hash it")
+	}
+	body, err := stream.reopen()
+	if err != nil {
+		return fmt.Errorf("opening body to hash it: %w", err)
+	}
+	defer body.Close()
+	_, err = io.Copy(h, body)
+	if err != nil {
+		return fmt.Errorf("hashing body: %w", err)
+	}
+	return nil
+}
+
+// apply authenticates the request. A streamed body (stream is not nil) is
+// not read into memory to sign it.
+func (auth RequestAuth) apply(req *http.Request, ds *datastore.Datastore, tokens *AuthTokenCache, stream *requestBodyStream) (err error) {
+	switch auth.Type {
+	case authTypeNone, authTypeDigest:
+		return nil
+	case authTypeBasic:
+		username, password, err := auth.Basic.credentials(ds)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(username, password)
+		return nil
+	case authTypeBearer:
+		token, err := authValue(auth.Bearer.Token, auth.Bearer.TokenFromStore, ds)
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			return fmt.Errorf("bearer token must not be empty")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	case authTypeOAuth2:
+		token, err := tokens.token(auth.OAuth2, ds)
+		if err != nil {
+			return err
+		}
+		token.SetAuthHeader(req)
+		return nil
+	case authTypeHMAC:
+		return auth.HMAC.sign(req, ds, stream)
+	case authTypeAWSSigV4:
+		return auth.AWSSigV4.sign(req, ds, time.Now(), stream)
+	default:
+		return fmt.Errorf("invalid auth type %q", auth.Type)
+	}
+}
+
+// bodySignatureHeaders returns the headers with a signature over the body
+func (auth RequestAuth) bodySignatureHeaders() (headers []string) {
+	switch auth.Type {
+	case authTypeHMAC:
+		if auth.HMAC.Header == "" {
+			return []string{"X-Signature"}
+		}
+		return []string{auth.HMAC.Header}
+	case authTypeAWSSigV4:
+		return []string{"Authorization", "X-Amz-Content-Sha256"}
+	default:
+		return nil
+	}
+}
+
+// authorization returns the value of the Authorization header for requests
+// which are not sent as http.Request, like grpc requests. The types which
+// sign the request or answer a challenge are not supported.
+func (auth RequestAuth) authorization(ds *datastore.Datastore, tokens *AuthTokenCache) (value string, err error) {
+	switch auth.Type {
+	case authTypeNone:
+		return "", nil
+	case authTypeBasic, authTypeBearer, authTypeOAuth2:
+		req := &http.Request{Header: http.Header{}}
+		err = auth.apply(req, ds, tokens, nil)
+		if err != nil {
+			return "", err
+		}
+		return req.Header.Get("Authorization"), nil
+	default:
+		return "", fmt.Errorf("auth %q is only supported for http requests", auth.Type)
+	}
+}
+
+// AuthTokenCache holds the oauth2 tokens of a suite. A token is requested
+// once and reused until it expires, then it is refreshed.
+type AuthTokenCache struct {
+	clients util.OAuthClientsConfig
+	mtx     sync.Mutex
+	sources map[string]oauth2.TokenSource
+}
+
+// NewAuthTokenCache returns an empty cache for the configured oauth2 clients
+func NewAuthTokenCache(clients util.OAuthClientsConfig) *AuthTokenCache {
+	return &AuthTokenCache{
+		clients: clients,
+		sources: map[string]oauth2.TokenSource{},
+	}
+}
+
+func (cache *AuthTokenCache) token(cfg requestAuthOAuth2, ds *datastore.Datastore) (token *oauth2.Token, err error) {
+	if cache == nil {
+		return nil, fmt.Errorf("oauth2 tokens can only be used within a suite")
+	}
+	client, ok := cache.clients[cfg.Client]
+	if !ok {
+		return nil, fmt.Errorf("oauth2 client %q is not configured", cfg.Client)
+	}
+
+	var key string
+	username, password, err := cfg.credentials(ds)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Grant {
+	case "", "client_credentials":
+		key = cfg.Client
+	case "password":
+		key = cfg.Client + "\x00" + username + "\x00" + password
+	default:
+		return nil, fmt.Errorf("invalid oauth2 grant %q", cfg.Grant)
+	}
+
+	cache.mtx.Lock()
+	ts, ok := cache.sources[key]
+	if !ok {
+		if cfg.Grant == "password" {
+			ts = client.PasswordCredentialsTokenSource(username, password)
+		} else {
+			ts = client.ClientCredentialsTokenSource()
+		}
+		cache.sources[key] = ts
+	}
+	cache.mtx.Unlock()
+
+	token, err = ts.Token()
+	if err != nil {
+		return nil, fmt.Errorf("getting oauth2 token for client %q: %w", cfg.Client, err)
+	}
+	return token, nil
+}
+
+// hmacCanonicalRequest builds the string to sign for canonicalization "request":
+//
+//	METHOD
+//	/escaped/path
+//	sorted=query&string
+//	signed-header-1:value
+//	...
+//	hex(sha256(body))
+func hmacCanonicalRequest(req *http.Request, signedHeaders []string, bodyHash []byte) (s string) {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	lines := []string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+	}
+	for _, name := range signedHeaders {
+		var value string
+		if strings.EqualFold(name, "host") {
+			value = requestHost(req)
+		} else {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		lines = append(lines, strings.ToLower(name)+":"+strings.TrimSpace(value))
+	}
+	lines = append(lines, hex.EncodeToString(bodyHash))
+
+	return strings.Join(lines, "\n")
+}
+
+func (cfg requestAuthHMAC) sign(req *http.Request, ds *datastore.Datastore, stream *requestBodyStream) (err error) {
+	if stream != nil && stream.reopen == nil {
+		return fmt.Errorf("hmac needs the whole body, it can't sign a generated body")
+	}
+
+	var newHash func() hash.Hash
+	switch cfg.Algorithm {
+	case "", "sha256":
+		newHash = sha256.New
+	case "sha1":
+		newHash = sha1.New
+	case "sha512":
+		newHash = sha512.New
+	case "md5":
+		newHash = md5.New
+	default:
+		return fmt.Errorf("invalid hmac algorithm %q", cfg.Algorithm)
+	}
+
+	key, err := authValue(cfg.Key, cfg.KeyFromStore, ds)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("hmac key must not be empty")
+	}
+
+	mac := hmac.New(newHash, []byte(key))
+	switch cfg.Canonicalization {
+	case "", "request":
+		bodyHash := sha256.New()
+		err = hashRequestBody(req, stream, bodyHash)
+		if err != nil {
+			return err
+		}
+		mac.Write([]byte(hmacCanonicalRequest(req, cfg.SignedHeaders, bodyHash.Sum(nil))))
+	case "body":
+		err = hashRequestBody(req, stream, mac)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid hmac canonicalization %q", cfg.Canonicalization)
+	}
+	sum := mac.Sum(nil)
+
+	var signature string
+	switch cfg.Encoding {
+	case "", "hex":
+		signature = hex.EncodeToString(sum)
+	case "base64":
+		signature = base64.StdEncoding.EncodeToString(sum)
+	default:
+		return fmt.Errorf("invalid hmac encoding %q", cfg.Encoding)
+	}
+
+	header := cfg.Header
+	if header == "" {
+		header = "X-Signature"
+	}
+	req.Header.Set(header, cfg.Prefix+signature)
+	return nil
+}
+
+// awsURIEncode encodes s as defined for the AWS Signature Version 4:
+// all bytes except the unreserved characters are percent encoded
+func awsURIEncode(s string, encodeSlash bool) (encoded string) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// awsSigV4IgnoredHeaders are not signed, they may be changed on the way
+var awsSigV4IgnoredHeaders = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"x-amzn-trace-id": true,
+	"content-length":  true,
+}
+
+func hmacSHA256(key []byte, data string) (sum []byte) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign implements https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func (cfg requestAuthAWSSigV4) sign(req *http.Request, ds *datastore.Datastore, now time.Time, stream *requestBodyStream) (err error) {
+	if cfg.Region == "" || cfg.Service == "" {
+		return fmt.Errorf("aws_sigv4 needs region and service")
+	}
+
+	accessKeyID, err := authValue(cfg.AccessKeyID, cfg.AccessKeyIDFromStore, ds)
+	if err != nil {
+		return err
+	}
+	secretAccessKey, err := authValue(cfg.SecretAccessKey, cfg.SecretAccessKeyFromStore, ds)
+	if err != nil {
+		return err
+	}
+	sessionToken, err := authValue(cfg.SessionToken, cfg.SessionTokenFromStore, ds)
+	if err != nil {
+		return err
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return fmt.Errorf("aws_sigv4 needs access_key_id and secret_access_key")
+	}
+
+	// a generated body is not signed, which is only supported by s3
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if stream != nil && stream.reopen == nil {
+		if cfg.Service != "s3" {
+			return fmt.Errorf("aws_sigv4 needs the whole body, it can only sign a generated body for service \"s3\"")
+		}
+	} else {
+		bodyHash := sha256.New()
+		err = hashRequestBody(req, stream, bodyHash)
+		if err != nil {
+			return err
+		}
+		payloadHash = hex.EncodeToString(bodyHash.Sum(nil))
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+	if cfg.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// canonical uri, s3 paths are not normalized and encoded only once
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if cfg.Service != "s3" {
+		path = awsURIEncode(path, false)
+	}
+
+	// canonical query string
+	query := req.URL.Query()
+	queryParts := []string{}
+	for key, values := range query {
+		for _, value := range values {
+			queryParts = append(queryParts, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(queryParts)
+
+	// canonical headers
+	headers := map[string]string{
+		"host": requestHost(req),
+	}
+	for name, values := range req.Header {
+		lname := strings.ToLower(name)
+		if awsSigV4IgnoredHeaders[lname] {
+			continue
+		}
+		trimmed := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers[lname] = strings.Join(trimmed, ",")
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Join(queryParts, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, cfg.Region, cfg.Service, "aws4_request"}, "/")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, cfg.Region)
+	signingKey = hmacSHA256(signingKey, cfg.Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature,
+	))
+	return nil
+}
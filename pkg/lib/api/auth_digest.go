@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+
+	"github.com/programmfabrik/apitest/pkg/lib/datastore"
+)
+
+// digestChallenge is the parsed "WWW-Authenticate: Digest ..." header
+type digestChallenge map[string]string
+
+// parseDigestChallenge returns the first digest challenge of the response
+// headers, nil if there is none
+func parseDigestChallenge(header http.Header) (challenge digestChallenge) {
+	for _, value := range header.Values("WWW-Authenticate") {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(value), " ")
+		if !strings.EqualFold(scheme, "digest") {
+			continue
+		}
+		return parseAuthParams(params)
+	}
+	return nil
+}
+
+// parseAuthParams parses comma separated key=value pairs, values may be
+// quoted strings containing commas
+func parseAuthParams(s string) (params map[string]string) {
+	params = map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		key, rest, found := strings.Cut(s, "=")
+		if !found {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			v, after, _ := strings.Cut(rest, ",")
+			value.WriteString(strings.TrimSpace(v))
+			s = after
+		}
+		params[key] = value.String()
+	}
+}
+
+// digestAuthorization answers the challenge as defined in RFC 7616
+func digestAuthorization(req *http.Request, cfg requestAuthUser, challenge digestChallenge, ds *datastore.Datastore) (authorization string, err error) {
+	username, password, err := cfg.credentials(ds)
+	if err != nil {
+		return "", err
+	}
+
+	algorithm := challenge["algorithm"]
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	case "SHA-512-256":
+		newHash = sha512.New512_256
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	// prefer "auth" over "auth-int" if the server offers both
+	var qop string
+	for _, q := range strings.Split(challenge["qop"], ",") {
+		q = strings.TrimSpace(q)
+		if q == "auth" || (q == "auth-int" && qop == "") {
+			qop = q
+		}
+	}
+
+	cnonceBytes := make([]byte, 16)
+	_, err = rand.Read(cnonceBytes)
+	if err != nil {
+		return "", fmt.Errorf("creating cnonce: %w", err)
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nc := "00000001"
+	nonce := challenge["nonce"]
+	realm := challenge["realm"]
+	uri := req.URL.RequestURI()
+
+	ha1 := h(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+	if qop == "auth-int" {
+		body, err := readRequestBody(req)
+		if err != nil {
+			return "", err
+		}
+		ha2 = h(req.Method + ":" + uri + ":" + h(string(body)))
+	}
+
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(strings.Join([]string{ha1, nonce, nc, cnonce, qop, ha2}, ":"))
+	}
+
+	parts := []string{
+		fmt.Sprintf("username=%q", username),
+		fmt.Sprintf("realm=%q", realm),
+		fmt.Sprintf("nonce=%q", nonce),
+		fmt.Sprintf("uri=%q", uri),
+	}
+	if algorithm != "" {
+		parts = append(parts, "algorithm="+algorithm)
+	}
+	if qop != "" {
+		parts = append(parts, "qop="+qop, "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce))
+	}
+	parts = append(parts, fmt.Sprintf("response=%q", response))
+	if opaque, ok := challenge["opaque"]; ok {
+		parts = append(parts, fmt.Sprintf("opaque=%q", opaque))
+	}
+	return "Digest " + strings.Join(parts, ", "), nil
+}
+
+// digestRoundTrip answers the digest challenge of a 401 response by sending
+// the request again with the Authorization header. Without a digest
+// challenge the response is returned unchanged.
+func (request Request) digestRoundTrip(client *http.Client, httpResponse *http.Response) (resp *http.Response, err error) {
+	challenge := parseDigestChallenge(httpResponse.Header)
+	if challenge == nil {
+		return httpResponse, nil
+	}
+	httpResponse.Body.Close()
+
+	httpRequest, err := request.buildHttpRequest()
+	if err != nil {
+		return nil, fmt.Errorf("could not buildHttpRequest: %w", err)
+	}
+	authorization, err := digestAuthorization(httpRequest, request.Auth.Digest, challenge, request.DataStore)
+	if err != nil {
+		closeRequestBody(httpRequest)
+		return nil, fmt.Errorf("answering digest challenge: %w", err)
+	}
+	httpRequest.Header.Set("Authorization", authorization)
+
+	return client.Do(httpRequest)
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/datastore"
+	"github.com/programmfabrik/apitest/pkg/lib/filesystem"
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	"github.com/programmfabrik/apitest/pkg/lib/util"
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/spf13/afero"
+)
+
+// TestAuth_AWSSigV4 uses "get-vanilla" from the AWS Signature Version 4 test suite
+func TestAuth_AWSSigV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	cfg := requestAuthAWSSigV4{
+		Region:          "us-east-1",
+		Service:         "service",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	err = cfg.sign(req, nil, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC), nil)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	go_test_utils.AssertStringEquals(t, req.Header.Get("X-Amz-Date"), "20150830T123600Z")
+	go_test_utils.AssertStringEquals(t,
+		req.Header.Get("Authorization"),
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+	)
+}
+
+func TestAuth_AWSURIEncode(t *testing.T) {
+	go_test_utils.AssertStringEquals(t, awsURIEncode("/a b/ü~", false), "/a%20b/%C3%BC~")
+	go_test_utils.AssertStringEquals(t, awsURIEncode("a/b=c", true), "a%2Fb%3Dc")
+}
+
+func TestAuth_HMACInBuildHttpRequest(t *testing.T) {
+	ds := datastore.NewStore(false)
+	err := ds.Set("key", "secret")
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	request := Request{
+		ServerURL: "http://localhost",
+		Method:    "POST",
+		Body: jsutil.Object{
+			"a": 1,
+		},
+		Auth: &RequestAuth{
+			Type: authTypeHMAC,
+			HMAC: requestAuthHMAC{
+				KeyFromStore:     "key",
+				Canonicalization: "body",
+			},
+		},
+		DataStore: ds,
+	}
+	httpRequest, err := request.buildHttpRequest()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	go_test_utils.AssertStringEquals(t, httpRequest.Header.Get("X-Signature"), "aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494")
+
+	body, err := io.ReadAll(httpRequest.Body)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, string(body), `{"a":1}`)
+}
+
+func TestAuth_StreamedBody(t *testing.T) {
+	request := Request{
+		ServerURL:    "http://localhost",
+		Method:       "PUT",
+		BodyType:     "generate",
+		BodyGenerate: requestBodyGenerate{Size: 1 << 20},
+		Auth: &RequestAuth{
+			Type: authTypeHMAC,
+			HMAC: requestAuthHMAC{Key: "secret"},
+		},
+	}
+	_, err := request.buildHttpRequest()
+	go_test_utils.ExpectError(t, err, "hmac did not fail for a streamed body")
+
+	request.Auth = &RequestAuth{
+		Type: authTypeAWSSigV4,
+		AWSSigV4: requestAuthAWSSigV4{
+			Region:          "us-east-1",
+			Service:         "service",
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "secret",
+		},
+	}
+	_, err = request.buildHttpRequest()
+	go_test_utils.ExpectError(t, err, "aws_sigv4 did not fail for a streamed body")
+
+	// s3 accepts an unsigned payload, the body is still streamed
+	request.Auth.AWSSigV4.Service = "s3"
+	httpRequest, err := request.buildHttpRequest()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, httpRequest.Header.Get("X-Amz-Content-Sha256"), "UNSIGNED-PAYLOAD")
+	go_test_utils.AssertIntEquals(t, int(httpRequest.ContentLength), 1<<20)
+	if httpRequest.GetBody != nil {
+		t.Errorf("expected the generated body to be streamed, not read into memory")
+	}
+}
+
+func TestAuth_FileBody(t *testing.T) {
+	filesystem.Fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(filesystem.Fs, "test/body.json", []byte(`{"a":1}`), 0644)
+
+	// the file is hashed separately, the signature is the same as for the
+	// body in memory
+	request := Request{
+		ServerURL:   "http://localhost",
+		Method:      "POST",
+		BodyType:    "file",
+		BodyFile:    "@body.json",
+		ManifestDir: "test/",
+		Auth: &RequestAuth{
+			Type: authTypeHMAC,
+			HMAC: requestAuthHMAC{
+				Key:              "secret",
+				Canonicalization: "body",
+			},
+		},
+	}
+	httpRequest, err := request.buildHttpRequest()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, httpRequest.Header.Get("X-Signature"), "aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494")
+	if httpRequest.GetBody != nil {
+		t.Errorf("expected the file body to be streamed, not read into memory")
+	}
+	body, err := io.ReadAll(httpRequest.Body)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, string(body), `{"a":1}`)
+
+	// the payload hash covers the encoded body which is sent
+	request.BodyEncoding = "gzip"
+	request.Auth = &RequestAuth{
+		Type: authTypeAWSSigV4,
+		AWSSigV4: requestAuthAWSSigV4{
+			Region:          "us-east-1",
+			Service:         "s3",
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "secret",
+		},
+	}
+	httpRequest, err = request.buildHttpRequest()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	body, err = io.ReadAll(httpRequest.Body)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	bodyHash := sha256.Sum256(body)
+	go_test_utils.AssertStringEquals(t, httpRequest.Header.Get("X-Amz-Content-Sha256"), hex.EncodeToString(bodyHash[:]))
+}
+
+func TestAuth_ToStringBodyEncoding(t *testing.T) {
+	request := Request{
+		ServerURL:    "http://localhost",
+		Method:       "POST",
+		Body:         jsutil.Object{"a": 1},
+		BodyEncoding: "gzip",
+		Auth: &RequestAuth{
+			Type: authTypeHMAC,
+			HMAC: requestAuthHMAC{Key: "secret"},
+		},
+	}
+	for _, curl := range []bool{false, true} {
+		s := request.ToString(curl)
+		if !strings.Contains(s, "X-Signature: [signature of the encoded body, not reproducible]") {
+			t.Errorf("signature not marked as not reproducible: %s", s)
+		}
+	}
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuth_Digest(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		params := parseDigestChallenge(http.Header{
+			"Www-Authenticate": []string{r.Header.Get("Authorization")},
+		})
+		if params == nil {
+			w.Header().Set("WWW-Authenticate", `Digest realm="test, realm", qop="auth", nonce="abc", opaque="xyz"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ha1 := md5Hex("user:test, realm:pass")
+		ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+		exp := md5Hex(ha1 + ":abc:" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+		if params["response"] != exp || params["opaque"] != "xyz" || params["uri"] != r.URL.RequestURI() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	request := Request{
+		ServerURL: ts.URL,
+		Endpoint:  "path",
+		Method:    "POST",
+		QueryParams: map[string]any{
+			"q": "1",
+		},
+		Body: jsutil.Object{
+			"a": 1,
+		},
+		Auth: &RequestAuth{
+			Type: authTypeDigest,
+			Digest: requestAuthUser{
+				Username: "user",
+				Password: "pass",
+			},
+		},
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, *response.StatusCode, 200)
+	go_test_utils.AssertIntEquals(t, int(atomic.LoadInt32(&calls)), 2)
+	go_test_utils.AssertStringEquals(t, string(response.Body), `{"a":1}`)
+}
+
+func TestAuth_OAuth2TokenCache(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		// the first token expires immediately (within the expiry delta of
+		// the oauth2 package) and must be refreshed
+		expiresIn := 1
+		if n > 1 {
+			expiresIn = 3600
+		}
+		fmt.Fprintf(w, `{"access_token":"token%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	defer ts.Close()
+
+	var clients util.OAuthClientsConfig
+	err := jsutil.Unmarshal([]byte(`{"my_client": {"client": "my_client", "secret": "s", "endpoint": {"token_url": "`+ts.URL+`"}}}`), &clients)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	cache := NewAuthTokenCache(clients)
+
+	for _, exp := range []string{"Bearer token1", "Bearer token2", "Bearer token2"} {
+		request := Request{
+			ServerURL: "http://localhost",
+			Auth: &RequestAuth{
+				Type: authTypeOAuth2,
+				OAuth2: requestAuthOAuth2{
+					Client: "my_client",
+				},
+			},
+			AuthTokens: cache,
+		}
+		httpRequest, err := request.buildHttpRequest()
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		go_test_utils.AssertStringEquals(t, httpRequest.Header.Get("Authorization"), exp)
+	}
+	go_test_utils.AssertIntEquals(t, int(atomic.LoadInt32(&calls)), 2)
+
+	request := Request{
+		ServerURL: "http://localhost",
+		Auth: &RequestAuth{
+			Type: authTypeOAuth2,
+			OAuth2: requestAuthOAuth2{
+				Client: "unknown",
+			},
+		},
+		AuthTokens: cache,
+	}
+	_, err = request.buildHttpRequest()
+	go_test_utils.ExpectError(t, err, "Expected error for unknown client")
+}
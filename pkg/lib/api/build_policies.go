@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"maps"
+	"mime"
 	"mime/multipart"
+	"net/textproto"
 	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -13,7 +17,44 @@ import (
 	"github.com/programmfabrik/apitest/pkg/lib/util"
 )
 
+// multipartPart is a part of a multipart body given as a list of parts.
+// The content of the part is either inline in "content" or loaded from
+// "file".
+type multipartPart struct {
+	Name        string            `json:"name"`     // form field name, required for "form-data"
+	Filename    string            `json:"filename"` // filename in the Content-Disposition
+	ContentType string            `json:"content_type"`
+	Header      map[string]string `json:"header"`
+	Content     any               `json:"content"` // a string is sent as is, other values as json
+	File        string            `json:"file"`    // path (or "@path") of a file or url
+}
+
+// multipartTypes are the possible values of body_multipart_type
+var multipartTypes = map[string]bool{
+	"form-data": true,
+	"related":   true,
+	"mixed":     true,
+}
+
+func (request Request) multipartType() (subtype string) {
+	if request.BodyMultipartType == "" {
+		return "form-data"
+	}
+	return request.BodyMultipartType
+}
+
 func buildMultipart(request Request) (additionalHeaders map[string]string, body io.Reader, err error) {
+	if !multipartTypes[request.multipartType()] {
+		return nil, nil, fmt.Errorf("body_multipart_type %q is not supported", request.BodyMultipartType)
+	}
+	parts, ok := request.Body.([]any)
+	if ok {
+		return buildMultipartParts(request, parts)
+	}
+	if request.multipartType() != "form-data" {
+		return nil, nil, fmt.Errorf("body_multipart_type %q needs a list of parts as body", request.BodyMultipartType)
+	}
+
 	additionalHeaders = make(map[string]string, 0)
 
 	var buf = bytes.NewBuffer([]byte{})
@@ -81,6 +122,176 @@ func buildMultipart(request Request) (additionalHeaders map[string]string, body
 	return
 }
 
+// buildMultipartParts builds the multipart body from the list of parts,
+// keeping their order
+func buildMultipartParts(request Request, partsData []any) (additionalHeaders map[string]string, body io.Reader, err error) {
+	partsBytes, err := jsutil.Marshal(partsData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshaling multipart parts: %w", err)
+	}
+	var parts []multipartPart
+	err = jsutil.Unmarshal(partsBytes, &parts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshaling multipart parts: %w", err)
+	}
+
+	var buf = bytes.NewBuffer([]byte{})
+	w := multipart.NewWriter(buf)
+	subtype := request.multipartType()
+
+	var rootContentType string
+	for idx, part := range parts {
+		contentType, err := writeMultipartPart(w, subtype, part, request.ManifestDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("multipart part %d: %w", idx, err)
+		}
+		if idx == 0 {
+			rootContentType = contentType
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := map[string]string{
+		"boundary": w.Boundary(),
+	}
+	// the root part of multipart/related is the first part (RFC 2387)
+	if subtype == "related" && rootContentType != "" {
+		params["type"] = rootContentType
+	}
+	additionalHeaders = map[string]string{
+		"Content-Type": mime.FormatMediaType("multipart/"+subtype, params),
+	}
+	return additionalHeaders, buf, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// writeMultipartPart writes the part and returns its content type
+func writeMultipartPart(w *multipart.Writer, subtype string, part multipartPart, manifestDir string) (contentType string, err error) {
+	if part.File != "" && part.Content != nil {
+		return "", fmt.Errorf("only one of content and file can be set")
+	}
+	if subtype == "form-data" && part.Name == "" {
+		return "", fmt.Errorf("name is required for form-data")
+	}
+
+	header := textproto.MIMEHeader{}
+	for k, v := range part.Header {
+		header.Set(k, v)
+	}
+
+	var content io.Reader
+	switch {
+	case part.File != "":
+		path := part.File
+		pathSpec, err := util.ParsePathSpec(part.File)
+		if err == nil && pathSpec != nil {
+			path = pathSpec.Path
+		}
+		file, err := util.OpenFileOrUrl(path, manifestDir)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		content = file
+		if part.ContentType == "" {
+			part.ContentType = "application/octet-stream"
+		}
+	default:
+		switch v := part.Content.(type) {
+		case nil:
+			content = strings.NewReader("")
+		case string:
+			content = strings.NewReader(v)
+		default:
+			data, err := jsutil.Marshal(v)
+			if err != nil {
+				return "", fmt.Errorf("marshaling content: %w", err)
+			}
+			content = bytes.NewReader(data)
+			if part.ContentType == "" {
+				part.ContentType = "application/json"
+			}
+		}
+	}
+
+	if header.Get("Content-Disposition") == "" {
+		var disposition string
+		switch {
+		case subtype == "form-data":
+			disposition = fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(part.Name))
+			if part.Filename != "" {
+				disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(part.Filename))
+			}
+		case part.Filename != "":
+			disposition = fmt.Sprintf(`attachment; filename="%s"`, quoteEscaper.Replace(part.Filename))
+		}
+		if disposition != "" {
+			header.Set("Content-Disposition", disposition)
+		}
+	}
+	if part.ContentType != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", part.ContentType)
+	}
+
+	pw, err := w.CreatePart(header)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(pw, content)
+	return header.Get("Content-Type"), err
+}
+
+// curlMultipartParts returns the curl -F options for the list of parts of
+// a multipart/form-data body
+func (request Request) curlMultipartParts(partsData []any) (opts string) {
+	partsBytes, _ := jsutil.Marshal(partsData)
+	var parts []multipartPart
+	_ = jsutil.Unmarshal(partsBytes, &parts)
+
+	// values in double quotes can contain ";" and start with "@" or "<"
+	curlQuote := func(s string) string {
+		return `"` + quoteEscaper.Replace(s) + `"`
+	}
+	for _, part := range parts {
+		var value string
+		if part.File != "" {
+			path := part.File
+			pathSpec, err := util.ParsePathSpec(part.File)
+			if err == nil && pathSpec != nil {
+				path = pathSpec.Path
+			}
+			value = "@" + util.LocalPath(path, request.ManifestDir)
+		} else {
+			switch v := part.Content.(type) {
+			case nil:
+			case string:
+				value = curlQuote(v)
+			default:
+				data, _ := jsutil.Marshal(v)
+				value = curlQuote(string(data))
+				if part.ContentType == "" {
+					part.ContentType = "application/json"
+				}
+			}
+		}
+		if part.Filename != "" {
+			value += ";filename=" + curlQuote(part.Filename)
+		}
+		if part.ContentType != "" {
+			value += ";type=" + part.ContentType
+		}
+		for _, k := range slices.Sorted(maps.Keys(part.Header)) {
+			value += ";headers=" + curlQuote(k+": "+part.Header[k])
+		}
+		opts += " -F " + curlEscape(part.Name+"="+value)
+	}
+	return opts
+}
+
 func buildUrlencoded(request Request) (additionalHeaders map[string]string, body io.Reader, err error) {
 	additionalHeaders = make(map[string]string, 0)
 	additionalHeaders["Content-Type"] = "application/x-www-form-urlencoded"
@@ -141,3 +352,40 @@ func buildFile(req Request) (headers map[string]string, file io.Reader, err erro
 	}
 	return headers, file, err
 }
+
+// generatedBody repeats the pattern until size bytes are read
+type generatedBody struct {
+	pattern []byte
+	size    int64
+	read    int64
+}
+
+func (g *generatedBody) Read(p []byte) (n int, err error) {
+	if g.read >= g.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > g.size-g.read {
+		p = p[:g.size-g.read]
+	}
+	for n < len(p) {
+		n += copy(p[n:], g.pattern[(g.read+int64(n))%int64(len(g.pattern)):])
+	}
+	g.read += int64(n)
+	return n, nil
+}
+
+// buildGenerate generates a body of the configured size, which is never
+// held in memory as a whole
+func buildGenerate(req Request) (headers map[string]string, body io.Reader, err error) {
+	if req.BodyGenerate.Size < 0 {
+		return nil, nil, fmt.Errorf("body_generate: size must not be negative")
+	}
+	pattern := []byte(req.BodyGenerate.Pattern)
+	if len(pattern) == 0 {
+		pattern = []byte{0}
+	}
+	headers = map[string]string{
+		"Content-Type": "application/octet-stream",
+	}
+	return headers, &generatedBody{pattern: pattern, size: req.BodyGenerate.Size}, nil
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"bytes"
 	"fmt"
+	"mime"
+	"mime/multipart"
 	"strings"
 	"testing"
 
@@ -93,3 +95,109 @@ func errorStringIfNotNil(err error) (errS string) {
 	}
 	return err.Error()
 }
+
+func TestBuildMultipart_Parts(t *testing.T) {
+	filesystem.Fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(filesystem.Fs, "test/image.png", []byte("png"), 0644)
+
+	testRequest := Request{
+		Body: []any{
+			map[string]any{"name": "meta", "content": map[string]any{"a": 1}},
+			map[string]any{"name": "tag", "content": "x;y"},
+			map[string]any{"name": "tag", "content": "z", "header": map[string]any{"X-Part": "2"}},
+			map[string]any{"name": "file", "file": "@image.png", "filename": "a.png", "content_type": "image/png"},
+		},
+		ManifestDir: "test/",
+		BodyType:    "multipart",
+	}
+
+	httpRequest, err := testRequest.buildHttpRequest()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	testReader, err := httpRequest.MultipartReader()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	for _, exp := range []struct {
+		name, filename, contentType, header, content string
+	}{
+		{"meta", "", "application/json", "", `{"a":1}`},
+		{"tag", "", "", "", "x;y"},
+		{"tag", "", "", "2", "z"},
+		{"file", "a.png", "image/png", "", "png"},
+	} {
+		part, err := testReader.NextPart()
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		go_test_utils.AssertStringEquals(t, part.FormName(), exp.name)
+		go_test_utils.AssertStringEquals(t, part.FileName(), exp.filename)
+		go_test_utils.AssertStringEquals(t, part.Header.Get("Content-Type"), exp.contentType)
+		go_test_utils.AssertStringEquals(t, part.Header.Get("X-Part"), exp.header)
+		buf := new(bytes.Buffer)
+		_, err = buf.ReadFrom(part)
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		go_test_utils.AssertStringEquals(t, buf.String(), exp.content)
+	}
+
+	curl := testRequest.ToString(true)
+	for _, opt := range []string{
+		`-F 'meta="{\"a\":1}";type=application/json'`,
+		`-F 'tag="x;y"'`,
+		`-F 'tag="z";headers="X-Part: 2"'`,
+		`-F 'file=@test/image.png;filename="a.png";type=image/png'`,
+	} {
+		if !strings.Contains(curl, opt) {
+			t.Errorf("expected %s in curl command %s", opt, curl)
+		}
+	}
+}
+
+func TestBuildMultipart_Related(t *testing.T) {
+	testRequest := Request{
+		Body: []any{
+			map[string]any{"content": map[string]any{"a": 1}, "header": map[string]any{"Content-ID": "<root>"}},
+			map[string]any{"content": "attachment", "filename": "a.txt", "content_type": "text/plain"},
+		},
+		BodyType:          "multipart",
+		BodyMultipartType: "related",
+	}
+
+	httpRequest, err := testRequest.buildHttpRequest()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	mediaType, params, err := mime.ParseMediaType(httpRequest.Header.Get("Content-Type"))
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, mediaType, "multipart/related")
+	go_test_utils.AssertStringEquals(t, params["type"], "application/json")
+
+	testReader := multipart.NewReader(httpRequest.Body, params["boundary"])
+	part, err := testReader.NextPart()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, part.Header.Get("Content-ID"), "<root>")
+	go_test_utils.AssertStringEquals(t, part.Header.Get("Content-Disposition"), "")
+	part, err = testReader.NextPart()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, part.Header.Get("Content-Disposition"), `attachment; filename="a.txt"`)
+
+	// curl -F would send multipart/form-data, the built body is sent instead
+	curl := testRequest.ToString(true)
+	if strings.Contains(curl, " -F ") {
+		t.Errorf("expected no -F option in curl command %s", curl)
+	}
+	for _, opt := range []string{
+		"printf '%s' '--",
+		"Content-Id: <root>",
+		`Content-Disposition: attachment; filename="a.txt"`,
+		" | curl ",
+		" --data-binary @- ",
+		"-H 'Content-Type: multipart/related; boundary=",
+	} {
+		if !strings.Contains(curl, opt) {
+			t.Errorf("expected %s in curl command %s", opt, curl)
+		}
+	}
+
+	testRequest.Body = map[string]any{"file": "@a.txt"}
+	_, _, err = buildMultipart(testRequest)
+	go_test_utils.ExpectError(t, err, "buildMultipart did not fail on related map body")
+
+	testRequest.BodyMultipartType = "alternative"
+	_, _, err = buildMultipart(testRequest)
+	go_test_utils.ExpectError(t, err, "buildMultipart did not fail on unsupported multipart type")
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+)
+
+// responseCookie is a cookie of the response in "set_cookies". It is also
+// used to set cookies in the cookie jar.
+type responseCookie struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Path        string `json:"path"`
+	Domain      string `json:"domain"`
+	Expires     string `json:"expires,omitempty"` // RFC 3339, not set for session cookies
+	MaxAge      int    `json:"max_age"`
+	Secure      bool   `json:"secure"`
+	HttpOnly    bool   `json:"http_only"`
+	SameSite    string `json:"same_site"` // "Lax", "Strict", "None" or ""
+	Partitioned bool   `json:"partitioned"`
+}
+
+var sameSiteNames = map[http.SameSite]string{
+	http.SameSiteLaxMode:    "Lax",
+	http.SameSiteStrictMode: "Strict",
+	http.SameSiteNoneMode:   "None",
+}
+
+// newResponseCookies keeps all cookies in the order of the Set-Cookie
+// headers, also cookies with the same name
+func newResponseCookies(cookies []*http.Cookie) (rcks []responseCookie) {
+	rcks = []responseCookie{}
+	for _, ck := range cookies {
+		if ck == nil {
+			continue
+		}
+		rck := responseCookie{
+			Name:        ck.Name,
+			Value:       ck.Value,
+			Path:        ck.Path,
+			Domain:      ck.Domain,
+			MaxAge:      ck.MaxAge,
+			Secure:      ck.Secure,
+			HttpOnly:    ck.HttpOnly,
+			SameSite:    sameSiteNames[ck.SameSite],
+			Partitioned: ck.Partitioned,
+		}
+		if !ck.Expires.IsZero() {
+			rck.Expires = ck.Expires.UTC().Format(time.RFC3339)
+		}
+		rcks = append(rcks, rck)
+	}
+	return rcks
+}
+
+func (rck responseCookie) httpCookie() (ck *http.Cookie, err error) {
+	ck = &http.Cookie{
+		Name:        rck.Name,
+		Value:       rck.Value,
+		Path:        rck.Path,
+		Domain:      rck.Domain,
+		MaxAge:      rck.MaxAge,
+		Secure:      rck.Secure,
+		HttpOnly:    rck.HttpOnly,
+		Partitioned: rck.Partitioned,
+	}
+	if rck.Expires != "" {
+		ck.Expires, err = time.Parse(time.RFC3339, rck.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("cookie %q: parsing expires: %w", rck.Name, err)
+		}
+	}
+	for sameSite, name := range sameSiteNames {
+		if strings.EqualFold(rck.SameSite, name) {
+			ck.SameSite = sameSite
+		}
+	}
+	return ck, nil
+}
+
+// cookieJarRequest inspects or changes the cookie jar of the suite for the
+// url of the request, instead of sending the request
+type cookieJarRequest struct {
+	Action  string           `yaml:"action" json:"action"`   // "get" (default), "set" or "clear"
+	Cookies []responseCookie `yaml:"cookies" json:"cookies"` // cookies for "set"
+}
+
+// jarCookie is a cookie as it is sent from the jar
+type jarCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (request Request) sendCookieJar() (response Response, err error) {
+	if request.CookieJar == nil {
+		return response, fmt.Errorf(`cookie_jar request needs "cookie_jar": true in the manifest`)
+	}
+	// the request is not sent, only its url is used
+	request.Auth = nil
+	httpRequest, err := request.buildHttpRequest()
+	if err != nil {
+		return response, fmt.Errorf("could not buildHttpRequest: %w", err)
+	}
+	closeRequestBody(httpRequest)
+	u := httpRequest.URL
+
+	switch request.Jar.Action {
+	case "", "get":
+	case "set":
+		cookies := []*http.Cookie{}
+		for _, rck := range request.Jar.Cookies {
+			ck, err := rck.httpCookie()
+			if err != nil {
+				return response, err
+			}
+			cookies = append(cookies, ck)
+		}
+		request.CookieJar.SetCookies(u, cookies)
+	case "clear":
+		clearJarCookies(request.CookieJar, u)
+	default:
+		return response, fmt.Errorf("cookie_jar: unknown action %q", request.Jar.Action)
+	}
+
+	// the jar only returns name and value of the cookies
+	jcks := []jarCookie{}
+	for _, ck := range request.CookieJar.Cookies(u) {
+		jcks = append(jcks, jarCookie{Name: ck.Name, Value: ck.Value})
+	}
+	body, err := jsutil.Marshal(jcks)
+	if err != nil {
+		return response, err
+	}
+	return NewResponse(new(http.StatusOK), nil, nil, strings.NewReader(string(body)), nil, ResponseFormat{})
+}
+
+// clearJarCookies removes all cookies of the jar which are sent to u. As
+// the jar does not tell path and domain of its cookies, the cookies are
+// expired for every path and domain which matches u.
+func clearJarCookies(jar http.CookieJar, u *url.URL) {
+	for _, ck := range jar.Cookies(u) {
+		expired := []*http.Cookie{}
+		for _, domain := range cookieDomains(u.Hostname()) {
+			for _, path := range cookiePaths(u.Path) {
+				expired = append(expired, &http.Cookie{
+					Name:   ck.Name,
+					Path:   path,
+					Domain: domain,
+					MaxAge: -1,
+				})
+			}
+		}
+		jar.SetCookies(u, expired)
+	}
+}
+
+// cookieDomains returns "" for host cookies and the host with all its
+// parent domains
+func cookieDomains(host string) (domains []string) {
+	domains = []string{""}
+	if net.ParseIP(host) != nil {
+		return append(domains, host)
+	}
+	parts := strings.Split(host, ".")
+	for i := range parts {
+		domains = append(domains, strings.Join(parts[i:], "."))
+	}
+	return domains
+}
+
+// cookiePaths returns all paths a cookie sent to path can have
+func cookiePaths(path string) (paths []string) {
+	paths = []string{"/"}
+	for i := 1; i < len(path); i++ {
+		if path[i] == '/' {
+			paths = append(paths, path[:i], path[:i+1])
+		}
+	}
+	if len(path) > 1 {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func (request Request) cookieJarToString() (res string) {
+	request.Auth = nil
+	httpRequest, err := request.buildHttpRequest()
+	if err != nil {
+		return fmt.Sprintf("could not build httpRequest: %s", err.Error())
+	}
+	closeRequestBody(httpRequest)
+	action := request.Jar.Action
+	if action == "" {
+		action = "get"
+	}
+	res = fmt.Sprintf("cookie_jar %s %s", action, httpRequest.URL)
+	for _, rck := range request.Jar.Cookies {
+		ck, err := rck.httpCookie()
+		if err != nil {
+			return res + "\n" + err.Error()
+		}
+		res += "\n" + ck.String()
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/tidwall/gjson"
+)
+
+func TestCookies_SetCookies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "sess=a; Path=/; HttpOnly; SameSite=Lax")
+		w.Header().Add("Set-Cookie", "sess=b; Path=/admin; Secure; Expires=Wed, 21 Oct 2037 07:28:00 GMT")
+	}))
+	defer ts.Close()
+
+	request := Request{
+		ServerURL: ts.URL,
+		Method:    "GET",
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	jsonStr, err := response.ServerResponseToJsonString(false)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "set_cookies.#").String(), "2")
+	for path, exp := range map[string]string{
+		"set_cookies.0.value":     "a",
+		"set_cookies.0.http_only": "true",
+		"set_cookies.0.same_site": "Lax",
+		"set_cookies.0.expires":   "",
+		"set_cookies.1.value":     "b",
+		"set_cookies.1.path":      "/admin",
+		"set_cookies.1.secure":    "true",
+		"set_cookies.1.expires":   "2037-10-21T07:28:00Z",
+		"set_cookies.1.same_site": "",
+	} {
+		go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, path).String(), exp)
+	}
+}
+
+func TestCookies_Jar(t *testing.T) {
+	jar, err := cookiejar.New(nil)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	jarRequest := func(endpoint string, jr cookieJarRequest) (body string) {
+		request := Request{
+			ServerURL: "http://sub.example.com",
+			Endpoint:  endpoint,
+			CookieJar: jar,
+			Jar:       &jr,
+		}
+		response, err := request.Send()
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		return string(response.Body)
+	}
+
+	body := jarRequest("admin/users", cookieJarRequest{
+		Action: "set",
+		Cookies: []responseCookie{
+			{Name: "root", Value: "1", Path: "/"},
+			{Name: "admin", Value: "2", Path: "/admin"},
+			{Name: "domain", Value: "3", Domain: "example.com", Path: "/admin/"},
+		},
+	})
+	go_test_utils.AssertStringEquals(t, body, `[{"name":"domain","value":"3"},{"name":"admin","value":"2"},{"name":"root","value":"1"}]`)
+	go_test_utils.AssertStringEquals(t, jarRequest("", cookieJarRequest{}), `[{"name":"root","value":"1"}]`)
+
+	body = jarRequest("admin/users", cookieJarRequest{Action: "clear"})
+	go_test_utils.AssertStringEquals(t, body, `[]`)
+	go_test_utils.AssertStringEquals(t, jarRequest("", cookieJarRequest{}), `[]`)
+
+	// the request is not sent, so a failing auth is not applied
+	request := Request{
+		ServerURL: "http://sub.example.com",
+		CookieJar: jar,
+		Jar:       &cookieJarRequest{},
+		Auth:      &RequestAuth{Type: authTypeOAuth2, OAuth2: requestAuthOAuth2{Client: "missing"}},
+	}
+	_, err = request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	_, err = Request{ServerURL: "http://example.com", Jar: &cookieJarRequest{}}.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail without cookie jar")
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unixSocketScheme prefixes a server_url which is the path of a Unix domain
+// socket, e.g. "unix:///run/admin.sock". The requests are sent with the
+// host "localhost".
+const unixSocketScheme = "unix://"
+
+func (request Request) unixSocket() (socket string, ok bool) {
+	return strings.CutPrefix(request.ServerURL, unixSocketScheme)
+}
+
+// dialContext returns the dial function for the transport of the request,
+// nil if the default dialer is used. Like curl --resolve, the address is
+// only replaced when dialing, the Host header and TLS server name are
+// those of the url.
+func (request Request) dialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	socket, isUnix := request.unixSocket()
+	if !isUnix && len(request.Resolve) == 0 {
+		return nil
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if isUnix {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		return dialer.DialContext(ctx, network, resolveAddr(request.Resolve, addr))
+	}
+}
+
+// transports caches the transports of requests with a custom dial target
+// or proxy, by transportKey
+var transports sync.Map
+
+// transport returns the transport for the dial target and proxy of the
+// request, nil if the shared transport of httpClient is used. As
+// connections are pooled by the url host, requests with a different dial
+// target or proxy never share a transport and its connections.
+func (request Request) transport() (tr *http.Transport, err error) {
+	socket, isUnix := request.unixSocket()
+	if !isUnix && len(request.Resolve) == 0 && request.Proxy == "" {
+		return nil, nil
+	}
+	// maps are printed with sorted keys
+	transportKey := fmt.Sprintf("%s\n%s\n%v", socket, request.Proxy, request.Resolve)
+	cached, ok := transports.Load(transportKey)
+	if ok {
+		return cached.(*http.Transport), nil
+	}
+
+	tr = httpClient.Transport.(*http.Transport).Clone()
+	if isUnix {
+		tr.Proxy = nil
+	} else {
+		tr.Proxy, err = request.proxyFunc()
+		if err != nil {
+			return nil, err
+		}
+	}
+	dial := request.dialContext()
+	if dial != nil {
+		tr.DialContext = dial
+	}
+	cached, _ = transports.LoadOrStore(transportKey, tr)
+	return cached.(*http.Transport), nil
+}
+
+// CloseTransports closes the idle connections of the cached transports and
+// removes them from the cache. It is called at the end of a suite, so the
+// transports of templated dial targets or proxies don't pile up.
+func CloseTransports() {
+	transports.Range(func(key, value any) bool {
+		transports.Delete(key)
+		value.(*http.Transport).CloseIdleConnections()
+		return true
+	})
+}
+
+// resolveAddr looks up "host:port" and then "host" of addr in resolve. The
+// target address can omit the port to keep the port of addr.
+func resolveAddr(resolve map[string]string, addr string) (target string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	target, ok := resolve[addr]
+	if !ok {
+		target, ok = resolve[host]
+		if !ok {
+			return addr
+		}
+	}
+	_, _, err = net.SplitHostPort(target)
+	if err != nil {
+		return net.JoinHostPort(target, port)
+	}
+	return target
+}
+
+// curlDialOptions returns the curl options for the unix socket or the
+// resolved addresses, "" if there are none
+func (request Request) curlDialOptions() (opts string) {
+	socket, isUnix := request.unixSocket()
+	if isUnix {
+		return "--unix-socket " + curlEscape(socket) + " "
+	}
+	for _, from := range slices.Sorted(maps.Keys(request.Resolve)) {
+		// --connect-to HOST1:PORT1:HOST2:PORT2, an empty port matches any
+		// port or keeps the port
+		opts += "--connect-to " + curlEscape(curlHostPort(from)+":"+curlHostPort(request.Resolve[from])) + " "
+	}
+	return opts
+}
+
+func curlHostPort(addr string) (hostPort string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return host + ":" + port
+}
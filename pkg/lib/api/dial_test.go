@@ -0,0 +1,103 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+)
+
+func echoHostServer() (ts *httptest.Server) {
+	return httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"host":%q,"path":%q}`, r.Host, r.URL.Path)
+	}))
+}
+
+func TestDial_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	lis, err := net.Listen("unix", socket)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	ts := echoHostServer()
+	ts.Listener = lis
+	ts.Start()
+	defer ts.Close()
+
+	request := Request{
+		ServerURL: "unix://" + socket,
+		Endpoint:  "status",
+		Method:    "GET",
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, string(response.Body), `{"host":"localhost","path":"/status"}`)
+
+	exp := `curl --unix-socket '` + socket + `' -X 'GET' -d '' -H 'Content-Type: application/json' -H 'User-Agent: ' 'http://localhost/status'`
+	go_test_utils.AssertStringEquals(t, request.ToString(true), exp)
+}
+
+func TestDial_Resolve(t *testing.T) {
+	ts := echoHostServer()
+	ts.Start()
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	for _, resolve := range []map[string]string{
+		{"apitest.invalid": "127.0.0.1"},
+		{"apitest.invalid:80": "127.0.0.1:" + port},
+	} {
+		request := Request{
+			ServerURL: "http://apitest.invalid:" + port,
+			Method:    "GET",
+			Resolve:   resolve,
+		}
+		if _, ok := resolve["apitest.invalid:80"]; ok {
+			request.ServerURL = "http://apitest.invalid"
+		}
+		response, err := request.Send()
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		go_test_utils.AssertIntEquals(t, *response.StatusCode, 200)
+		go_test_utils.AssertStringEquals(t, string(response.Body), fmt.Sprintf(`{"host":%q,"path":"/"}`, request.ServerURL[len("http://"):]))
+	}
+}
+
+func TestDial_CloseTransports(t *testing.T) {
+	for i := range 3 {
+		request := Request{
+			ServerURL: "http://apitest.invalid",
+			Resolve:   map[string]string{"apitest.invalid": fmt.Sprintf("127.0.0.%d", i+1)},
+		}
+		_, err := request.transport()
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	}
+
+	CloseTransports()
+	transports.Range(func(key, value any) bool {
+		t.Errorf("transport %q was not removed", key)
+		return true
+	})
+}
+
+func TestDial_ResolveAddr(t *testing.T) {
+	resolve := map[string]string{
+		"a.example:443": "10.0.0.1:8443",
+		"a.example":     "10.0.0.2",
+		"b.example":     "::1",
+	}
+	for addr, exp := range map[string]string{
+		"a.example:443": "10.0.0.1:8443",
+		"a.example:80":  "10.0.0.2:80",
+		"b.example:80":  "[::1]:80",
+		"c.example:80":  "c.example:80",
+	} {
+		go_test_utils.AssertStringEquals(t, resolveAddr(resolve, addr), exp)
+	}
+
+	request := Request{Resolve: resolve}
+	go_test_utils.AssertStringEquals(t, request.curlDialOptions(),
+		"--connect-to 'a.example::10.0.0.2:' --connect-to 'a.example:443:10.0.0.1:8443' --connect-to 'b.example::[::1]:' ")
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// responseEncoding is added to the response as "encoding" if the response
+// format has "decompress" set
+type responseEncoding struct {
+	ContentEncoding  string `json:"content_encoding"`
+	CompressedSize   int64  `json:"compressed_size"`
+	DecompressedSize int64  `json:"decompressed_size"`
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// contentDecoder returns a reader decoding r for a single content coding
+func contentDecoder(coding string, r io.Reader) (dec io.Reader, err error) {
+	switch coding {
+	case "", "identity":
+		return r, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// "deflate" should be zlib wrapped, but some servers send the raw
+		// deflate stream
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", coding)
+	}
+}
+
+// lazyDecoder creates the decoder on the first read. Most decoders read a
+// header when they are created, this would fail for an empty body, and it
+// would block before the reading of an event stream is limited.
+type lazyDecoder struct {
+	coding string
+	r      *bufio.Reader
+	dec    io.Reader
+}
+
+func (ld *lazyDecoder) Read(p []byte) (n int, err error) {
+	if ld.dec == nil {
+		_, err = ld.r.Peek(1)
+		if err == io.EOF {
+			// a body without content is not decoded, e.g. for HEAD, 204
+			// or 304 responses
+			ld.dec = ld.r
+		} else {
+			ld.dec, err = contentDecoder(ld.coding, ld.r)
+			if err != nil {
+				return 0, fmt.Errorf("decoding %q: %w", ld.coding, err)
+			}
+		}
+	}
+	return ld.dec.Read(p)
+}
+
+// decodeContent returns a reader decoding the body for the Content-Encoding
+// header. The codings are removed in reverse order of how they were applied.
+func decodeContent(contentEncoding string, body io.Reader) (dec io.Reader, err error) {
+	dec = body
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		// the coding is checked now, the decoder is created on the first read
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate", "br", "zstd":
+			dec = &lazyDecoder{coding: coding, r: bufio.NewReader(dec)}
+		default:
+			return nil, fmt.Errorf("decoding %q: unsupported content encoding %q", coding, coding)
+		}
+	}
+	return dec, nil
+}
+
+// contentEncodingTools are command line tools to compress a body, they are
+// used to show the body encoding in the curl command
+var contentEncodingTools = map[string]string{
+	"gzip":    "gzip -c",
+	"deflate": "pigz -z -c",
+	"br":      "brotli -c",
+	"zstd":    "zstd -c",
+}
+
+// encodeContent returns a reader compressing the body with the content
+// coding. The body is compressed while it is read, so it is never held in
+// memory as a whole.
+func encodeContent(coding string, body io.Reader) (encoded io.ReadCloser, err error) {
+	pr, pw := io.Pipe()
+	var w io.WriteCloser
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(pw)
+	case "deflate":
+		w = zlib.NewWriter(pw)
+	case "br":
+		w = brotli.NewWriter(pw)
+	case "zstd":
+		w, err = zstd.NewWriter(pw)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported body encoding %q", coding)
+	}
+
+	// The transport closes the reader in any case, this ends the copy
+	go func() {
+		if closer, ok := body.(io.Closer); ok {
+			defer closer.Close()
+		}
+		if body != nil {
+			_, err := io.Copy(w, body)
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("encoding body with %q: %w", coding, err))
+				return
+			}
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr, nil
+}
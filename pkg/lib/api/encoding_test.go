@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+)
+
+func TestDecodeContent(t *testing.T) {
+	data := []byte(`{"a":1}`)
+
+	compress := func(w io.WriteCloser, buf *bytes.Buffer, data []byte) []byte {
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+	var zlibBuf, flateBuf, gzipBuf, bothBuf bytes.Buffer
+	zlibData := compress(zlib.NewWriter(&zlibBuf), &zlibBuf, data)
+	fw, _ := flate.NewWriter(&flateBuf, flate.DefaultCompression)
+	flateData := compress(fw, &flateBuf, data)
+	gzipData := compress(gzip.NewWriter(&gzipBuf), &gzipBuf, data)
+	// first deflate, then gzip
+	bothData := compress(gzip.NewWriter(&bothBuf), &bothBuf, zlibData)
+
+	for _, tc := range []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"identity", "", data},
+		{"deflate zlib", "deflate", zlibData},
+		{"deflate raw", "deflate", flateData},
+		{"gzip", "GZIP", gzipData},
+		{"multiple", "deflate, gzip", bothData},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dec, err := decodeContent(tc.encoding, bytes.NewReader(tc.body))
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			got, err := io.ReadAll(dec)
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertStringEquals(t, string(got), string(data))
+		})
+	}
+
+	_, err := decodeContent("compress", bytes.NewReader(data))
+	go_test_utils.ExpectError(t, err, "Expected error for unsupported encoding")
+
+	// an empty body, e.g. of a HEAD request, is not decoded
+	dec, err := decodeContent("deflate, gzip", bytes.NewReader(nil))
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	got, err := io.ReadAll(dec)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, len(got), 0)
+}
+
+func TestBodyEncoding(t *testing.T) {
+	request := Request{
+		ServerURL: "http://localhost",
+		Method:    "POST",
+		Body: jsutil.Object{
+			"a": "it's",
+		},
+		BodyEncoding: "zstd",
+	}
+	httpRequest, err := request.buildHttpRequest()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, httpRequest.Header.Get("Content-Encoding"), "zstd")
+
+	dec, err := decodeContent("zstd", httpRequest.Body)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	body, err := io.ReadAll(dec)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, string(body), `{"a":"it's"}`)
+
+	exp := `printf '%s' '{"a":"it'\''s"}' | zstd -c | curl -X 'POST' --data-binary @- -H 'Content-Encoding: zstd' -H 'Content-Type: application/json' -H 'User-Agent: ' 'http://localhost'`
+	go_test_utils.AssertStringEquals(t, request.ToString(true), exp)
+
+	request.BodyEncoding = "lzma"
+	_, err = request.buildHttpRequest()
+	go_test_utils.ExpectError(t, err, "Expected error for unsupported body encoding")
+}
+
+func TestDecompress_EmptyBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		switch r.URL.Path {
+		case "/no-content":
+			w.WriteHeader(http.StatusNoContent)
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		method   string
+		endpoint string
+		status   int
+	}{
+		{"HEAD", "", http.StatusOK},
+		{"GET", "no-content", http.StatusNoContent},
+		{"GET", "not-modified", http.StatusNotModified},
+	} {
+		t.Run(tc.method+" "+tc.endpoint, func(t *testing.T) {
+			request := Request{
+				ServerURL:      ts.URL,
+				Endpoint:       tc.endpoint,
+				Method:         tc.method,
+				ResponseFormat: ResponseFormat{Decompress: true},
+			}
+			response, err := request.Send()
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertIntEquals(t, *response.StatusCode, tc.status)
+			go_test_utils.AssertIntEquals(t, len(response.Body), 0)
+		})
+	}
+}
+
+func TestBodyEncoding_NotSent(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// the signature fails for the streamed body, the request is not built
+	request := Request{
+		ServerURL:    "http://localhost",
+		Method:       "PUT",
+		BodyType:     "generate",
+		BodyGenerate: requestBodyGenerate{Size: 1 << 20},
+		BodyEncoding: "gzip",
+		Auth: &RequestAuth{
+			Type: authTypeHMAC,
+			HMAC: requestAuthHMAC{Key: "secret"},
+		},
+	}
+	_, err := request.buildHttpRequest()
+	go_test_utils.ExpectError(t, err, "hmac did not fail for a streamed body")
+
+	// the request is built, but never sent
+	request.Auth = nil
+	request.Proxy = "http://[::1"
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail for an invalid proxy")
+
+	request.Proxy = ""
+	request.Jar = &cookieJarRequest{}
+	request.CookieJar, err = cookiejar.New(nil)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	_, err = request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	// the goroutines encoding the bodies end
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() > before {
+		t.Errorf("expected %d goroutines, got %d", before, runtime.NumGoroutine())
+	}
+}
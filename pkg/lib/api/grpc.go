@@ -0,0 +1,560 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	"github.com/programmfabrik/apitest/pkg/lib/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcRequest turns the request into a unary gRPC call. The server_url is
+// the target: "grpc://" or "http://" (and no scheme) connect in plaintext,
+// "grpcs://" or "https://" use TLS.
+type grpcRequest struct {
+	Service  string         `yaml:"service" json:"service"`   // fully qualified service name, like "grpc.health.v1.Health"
+	Method   string         `yaml:"method" json:"method"`     // method name, like "Check"
+	Message  any            `yaml:"message" json:"message"`   // request message in protobuf JSON mapping
+	Metadata map[string]any `yaml:"metadata" json:"metadata"` // string or []string values
+	Protoset string         `yaml:"protoset" json:"protoset"` // compiled FileDescriptorSet, server reflection is used if empty
+}
+
+// grpcHTTPStatus maps the gRPC status codes to the closest HTTP status code,
+// so "statuscode" can be checked like for any other request
+var grpcHTTPStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// grpcTarget returns the dial target and the transport credentials for
+// the server url. A unix socket is connected in plaintext.
+func grpcTarget(serverURL string) (target string, creds credentials.TransportCredentials, err error) {
+	if strings.HasPrefix(serverURL, unixSocketScheme) {
+		// the socket is dialed by grpcDialOptions
+		return "localhost", insecure.NewCredentials(), nil
+	}
+	if !strings.Contains(serverURL, "://") {
+		return serverURL, insecure.NewCredentials(), nil
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing grpc server url %q: %w", serverURL, err)
+	}
+
+	switch u.Scheme {
+	case "grpc", "http":
+		return u.Host, insecure.NewCredentials(), nil
+	case "grpcs", "https":
+		return u.Host, credentials.NewTLS(&tls.Config{InsecureSkipVerify: true}), nil
+	default:
+		return "", nil, fmt.Errorf("unsupported grpc server url scheme %q", u.Scheme)
+	}
+}
+
+// grpcDialOptions returns the dial target and the options to connect to
+// the unix socket, the resolved addresses and through the proxy of the
+// request, like for http requests. Without these, gRPC uses the proxy of
+// the environment itself.
+func (request Request) grpcDialOptions(target string) (dialTarget string, opts []grpc.DialOption, err error) {
+	_, isUnix := request.unixSocket()
+	if !isUnix && len(request.Resolve) == 0 && request.Proxy == "" {
+		return target, nil, nil
+	}
+
+	var proxyFunc func(*http.Request) (*url.URL, error)
+	if !isUnix {
+		proxyFunc, err = request.proxyFunc()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	dial := dialFunc(request.dialContext())
+	if dial == nil {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		dial = dialer.DialContext
+	}
+
+	// with a custom dialer gRPC does not resolve the target itself, the
+	// port is needed to dial it
+	_, _, err = net.SplitHostPort(target)
+	if err != nil {
+		target = net.JoinHostPort(target, "443")
+	}
+
+	opts = []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			if proxyFunc != nil {
+				proxyURL, err := proxyFunc(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+				if err != nil {
+					return nil, err
+				}
+				if proxyURL != nil {
+					return dialProxy(ctx, dial, proxyURL, addr)
+				}
+			}
+			return dial(ctx, "tcp", addr)
+		}),
+	}
+	// "passthrough" hands the target to the dialer as it is, so it can be
+	// resolved like for http requests
+	return "passthrough:///" + target, opts, nil
+}
+
+// grpcMetadata converts the metadata from the manifest into outgoing metadata
+func grpcMetadata(md map[string]any) (out metadata.MD, err error) {
+	out = metadata.MD{}
+	for key, val := range md {
+		switch v := val.(type) {
+		case string:
+			out.Append(key, v)
+		case []any:
+			for _, vOne := range v {
+				vOneS, isString := vOne.(string)
+				if !isString {
+					return nil, fmt.Errorf("unsupported metadata %q with value %T: %v", key, vOne, vOne)
+				}
+				out.Append(key, vOneS)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported metadata value %T: %v", val, val)
+		}
+	}
+	return out, nil
+}
+
+// grpcMetadata returns the outgoing metadata of the grpc request, with the
+// "authorization" of the request auth
+func (request Request) grpcMetadata() (md metadata.MD, err error) {
+	md, err = grpcMetadata(request.GRPC.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if request.Auth == nil {
+		return md, nil
+	}
+	authorization, err := request.Auth.authorization(request.DataStore, request.AuthTokens)
+	if err != nil {
+		return nil, fmt.Errorf("applying auth %q: %w", request.Auth.Type, err)
+	}
+	if authorization != "" {
+		md.Set("authorization", authorization)
+	}
+	return md, nil
+}
+
+// metadataToMap converts the received metadata into the header format of the response
+func metadataToMap(md metadata.MD) (m map[string]any) {
+	m = map[string]any{}
+	for key, values := range md {
+		m[key] = values
+	}
+	return m
+}
+
+// protosetPath unwraps the path, if an @-notation path spec was passed
+func protosetPath(protoset string) (path string) {
+	pathSpec, err := util.ParsePathSpec(protoset)
+	if err == nil && pathSpec != nil {
+		return pathSpec.Path
+	}
+	return protoset
+}
+
+// loadProtoset reads a compiled FileDescriptorSet ("protoc --descriptor_set_out
+// --include_imports") from a local or remote file
+func loadProtoset(protoset, manifestDir string) (files *protoregistry.Files, err error) {
+	path := protosetPath(protoset)
+	file, err := util.OpenFileOrUrl(path, manifestDir)
+	if err != nil {
+		return nil, fmt.Errorf("opening protoset %q: %w", path, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading protoset %q: %w", path, err)
+	}
+
+	var fds descriptorpb.FileDescriptorSet
+	err = proto.Unmarshal(data, &fds)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling protoset %q: %w", path, err)
+	}
+
+	files, err = protodesc.NewFiles(&fds)
+	if err != nil {
+		return nil, fmt.Errorf("loading protoset %q: %w", path, err)
+	}
+	return files, nil
+}
+
+// reflectFiles resolves the file defining the service and all its
+// dependencies using the server reflection service
+func reflectFiles(ctx context.Context, conn *grpc.ClientConn, service string) (files *protoregistry.Files, err error) {
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("starting server reflection: %w", err)
+	}
+	defer stream.CloseSend()
+
+	fdProtos := map[string]*descriptorpb.FileDescriptorProto{}
+
+	request := func(req *reflectionpb.ServerReflectionRequest) (err error) {
+		err = stream.Send(req)
+		if err != nil {
+			return err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		errResp := resp.GetErrorResponse()
+		if errResp != nil {
+			return fmt.Errorf("%s", errResp.GetErrorMessage())
+		}
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			var fd descriptorpb.FileDescriptorProto
+			err = proto.Unmarshal(raw, &fd)
+			if err != nil {
+				return err
+			}
+			fdProtos[fd.GetName()] = &fd
+		}
+		return nil
+	}
+
+	err = request(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: service,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("resolving service %q via server reflection: %w", service, err)
+	}
+
+	// the server usually sends all dependencies along, fetch the missing ones
+	for {
+		var missing []string
+		for _, fd := range fdProtos {
+			for _, dep := range fd.GetDependency() {
+				_, ok := fdProtos[dep]
+				if !ok {
+					missing = append(missing, dep)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		for _, dep := range missing {
+			_, ok := fdProtos[dep]
+			if ok {
+				// requested already for another file
+				continue
+			}
+			err = request(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{
+					FileByFilename: dep,
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("resolving file %q via server reflection: %w", dep, err)
+			}
+			_, ok = fdProtos[dep]
+			if !ok {
+				return nil, fmt.Errorf("resolving file %q via server reflection: not sent by server", dep)
+			}
+		}
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range fdProtos {
+		fds.File = append(fds.File, fd)
+	}
+	files, err = protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("loading files from server reflection: %w", err)
+	}
+	return files, nil
+}
+
+// grpcMethodDescriptor finds the unary method in the resolved files
+func grpcMethodDescriptor(files *protoregistry.Files, service, method string) (md protoreflect.MethodDescriptor, err error) {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("finding service %q: %w", service, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a service", service)
+	}
+	md = sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service %q has no method %q", service, method)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method %q of service %q is streaming, only unary methods are supported", method, service)
+	}
+	return md, nil
+}
+
+// sendGRPC does the unary gRPC call and converts the result into a response.
+// A gRPC error status is not an error here, it is part of the response.
+func (request Request) sendGRPC() (response Response, err error) {
+	gr := request.GRPC
+	if gr.Service == "" || gr.Method == "" {
+		return response, fmt.Errorf("grpc request needs service and method")
+	}
+
+	target, creds, err := grpcTarget(request.ServerURL)
+	if err != nil {
+		return response, err
+	}
+
+	md, err := request.grpcMetadata()
+	if err != nil {
+		return response, err
+	}
+
+	timeout := httpClient.Timeout
+	if request.TimeoutMS > 0 {
+		timeout = time.Duration(request.TimeoutMS) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	now := time.Now()
+
+	dialTarget, dialOpts, err := request.grpcDialOptions(target)
+	if err != nil {
+		return response, err
+	}
+	conn, err := grpc.NewClient(dialTarget, append(dialOpts, grpc.WithTransportCredentials(creds))...)
+	if err != nil {
+		return response, fmt.Errorf("connecting to grpc server %q: %w", target, err)
+	}
+	defer conn.Close()
+
+	var files *protoregistry.Files
+	if gr.Protoset != "" {
+		files, err = loadProtoset(gr.Protoset, request.ManifestDir)
+	} else {
+		files, err = reflectFiles(ctx, conn, gr.Service)
+		if err != nil && request.ExpectError != "" && grpcTransportError(err, conn) {
+			return request.grpcExpectedErrorResponse(err, now)
+		}
+	}
+	if err != nil {
+		return response, err
+	}
+
+	methodDesc, err := grpcMethodDescriptor(files, gr.Service, gr.Method)
+	if err != nil {
+		return response, err
+	}
+
+	reqMsg := dynamicpb.NewMessage(methodDesc.Input())
+	if gr.Message != nil {
+		msgBytes, err := jsutil.Marshal(gr.Message)
+		if err != nil {
+			return response, fmt.Errorf("marshaling grpc message: %w", err)
+		}
+		err = protojson.UnmarshalOptions{Resolver: dynamicpb.NewTypes(files)}.Unmarshal(msgBytes, reqMsg)
+		if err != nil {
+			return response, fmt.Errorf("converting grpc message to %q: %w", methodDesc.Input().FullName(), err)
+		}
+	}
+	respMsg := dynamicpb.NewMessage(methodDesc.Output())
+
+	var header, trailer metadata.MD
+	err = conn.Invoke(
+		metadata.NewOutgoingContext(ctx, md),
+		fmt.Sprintf("/%s/%s", gr.Service, gr.Method),
+		reqMsg, respMsg,
+		grpc.Header(&header), grpc.Trailer(&trailer),
+	)
+	elapsedTime := time.Since(now)
+	if request.ExpectError != "" {
+		if err != nil && grpcTransportError(err, conn) {
+			return request.grpcExpectedErrorResponse(err, now)
+		}
+		return response, fmt.Errorf("expected error %q, got a response with status %s", request.ExpectError, status.Code(err))
+	}
+	st := status.Convert(err)
+
+	body := jsutil.Object{
+		"code":           int(st.Code()),
+		"status":         st.Code().String(),
+		"status_message": st.Message(),
+		"message":        nil,
+		"trailer":        metadataToMap(trailer),
+	}
+	if st.Code() == codes.OK {
+		msgBytes, err := protoJSONOptions(files).Marshal(respMsg)
+		if err != nil {
+			return response, fmt.Errorf("converting grpc response message: %w", err)
+		}
+		var msgJSON any
+		err = jsutil.UnmarshalPlain(msgBytes, &msgJSON)
+		if err != nil {
+			return response, fmt.Errorf("converting grpc response message: %w", err)
+		}
+		body["message"] = msgJSON
+	}
+
+	bodyBytes, err := jsutil.Marshal(body)
+	if err != nil {
+		return response, fmt.Errorf("marshaling grpc response: %w", err)
+	}
+
+	statusCode, ok := grpcHTTPStatus[st.Code()]
+	if !ok {
+		statusCode = http.StatusInternalServerError
+	}
+
+	response, err = NewResponse(new(statusCode), metadataToMap(header), nil, strings.NewReader(string(bodyBytes)), nil, ResponseFormat{})
+	if err != nil {
+		return response, fmt.Errorf("constructing response from grpc response: %w", err)
+	}
+	response.ReqDur = elapsedTime
+	return response, nil
+}
+
+// grpcTransportError returns if the call failed without an answer of the
+// server: the deadline was exceeded, or the connection could not be set up.
+// Only then expect_error of the request applies, other errors are part of
+// the response. An UNAVAILABLE status sent by the server is an answer, the
+// connection is ready then.
+func grpcTransportError(err error, conn *grpc.ClientConn) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		return true
+	case codes.Unavailable:
+		return conn.GetState() != connectivity.Ready
+	}
+	return false
+}
+
+func (request Request) grpcExpectedErrorResponse(err error, start time.Time) (response Response, errOut error) {
+	response, err = request.expectedErrorResponse(err)
+	if err != nil {
+		return response, fmt.Errorf("could not do grpc request: %w", err)
+	}
+	response.ReqDur = time.Since(start)
+	return response, nil
+}
+
+// grpcToString renders the grpc request for logging, as a grpcurl command
+// if curl is set
+func (request Request) grpcToString(curl bool) (res string) {
+	gr := request.GRPC
+
+	target, creds, err := grpcTarget(request.ServerURL)
+	if err != nil {
+		return fmt.Sprintf("could not build grpc request: %s", err.Error())
+	}
+	md, err := request.grpcMetadata()
+	if err != nil {
+		return fmt.Sprintf("could not build grpc request: %s", err.Error())
+	}
+	msg := []byte("{}")
+	if gr.Message != nil {
+		msg, err = jsutil.Marshal(gr.Message)
+		if err != nil {
+			return fmt.Sprintf("could not build grpc request: %s", err.Error())
+		}
+	}
+
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	socket, isUnix := request.unixSocket()
+	if curl {
+		// grpcurl takes the proxy from the environment
+		parts := []string{"grpcurl"}
+		switch {
+		case isUnix, request.Proxy == "":
+		case request.Proxy == proxyDirect:
+			parts = []string{"NO_PROXY='*'", "grpcurl"}
+		default:
+			parts = []string{"HTTPS_PROXY=" + curlEscape(request.Proxy), "grpcurl"}
+		}
+		if isUnix {
+			parts = append(parts, "-unix")
+			target = socket
+		}
+		if creds.Info().SecurityProtocol == "insecure" {
+			parts = append(parts, "-plaintext")
+		} else {
+			parts = append(parts, "-insecure")
+		}
+		if gr.Protoset != "" {
+			parts = append(parts, "-protoset", fmt.Sprintf("'%s'", util.LocalPath(protosetPath(gr.Protoset), request.ManifestDir)))
+		}
+		for _, key := range keys {
+			for _, val := range md[key] {
+				parts = append(parts, "-H", fmt.Sprintf("'%s: %s'", key, val))
+			}
+		}
+		parts = append(parts, "-d", fmt.Sprintf("'%s'", msg), fmt.Sprintf("'%s'", target), fmt.Sprintf("'%s/%s'", gr.Service, gr.Method))
+		return strings.Join(parts, " ")
+	}
+
+	if isUnix {
+		target = request.ServerURL
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "GRPC %s/%s\nTarget: %s\n", gr.Service, gr.Method, target)
+	for _, key := range keys {
+		for _, val := range md[key] {
+			fmt.Fprintf(&sb, "%s: %s\n", key, val)
+		}
+	}
+	fmt.Fprintf(&sb, "\n%s", msg)
+	return sb.String()
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	"github.com/programmfabrik/apitest/pkg/lib/util"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+type responseFormatImage struct {
+	Hash      bool   `json:"hash,omitempty"`      // add the perceptual hash of the image as "dhash"
+	Compare   string `json:"compare,omitempty"`   // "@path" of a reference image, adds "compare" with the distance of the hashes
+	Tolerance int    `json:"tolerance,omitempty"` // maximum distance (0 - 64) of the hashes for "compare.match"
+}
+
+// imageInfo is the decoded image of an "image" response
+type imageInfo struct {
+	Format     string         `json:"format"` // "png", "jpeg", "gif" or "webp"
+	Width      int            `json:"width"`
+	Height     int            `json:"height"`
+	ColorModel string         `json:"color_model"`
+	Exif       map[string]any `json:"exif"`
+	DHash      string         `json:"dhash,omitempty"` // 64 bit difference hash in hex
+	Compare    *imageCompare  `json:"compare,omitempty"`
+}
+
+// imageCompare is the result of the comparison against the reference image
+type imageCompare struct {
+	Distance int  `json:"distance"` // number of differing bits of the hashes
+	Match    bool `json:"match"`    // distance <= tolerance
+}
+
+// colorModelNames are the names of the color models of the standard library
+var colorModelNames = []struct {
+	model color.Model
+	name  string
+}{
+	{color.RGBAModel, "rgba"},
+	{color.RGBA64Model, "rgba64"},
+	{color.NRGBAModel, "nrgba"},
+	{color.NRGBA64Model, "nrgba64"},
+	{color.AlphaModel, "alpha"},
+	{color.Alpha16Model, "alpha16"},
+	{color.GrayModel, "gray"},
+	{color.Gray16Model, "gray16"},
+	{color.CMYKModel, "cmyk"},
+	{color.YCbCrModel, "ycbcr"},
+	{color.NYCbCrAModel, "nycbcra"},
+}
+
+func colorModelName(model color.Model) (name string) {
+	if _, ok := model.(color.Palette); ok {
+		return "paletted"
+	}
+	for _, cm := range colorModelNames {
+		if cm.model == model {
+			return cm.name
+		}
+	}
+	return "other"
+}
+
+// imageExif returns the exif fields of the image, rationals are strings like
+// "72/1". Images without exif data return an empty map.
+func imageExif(raw []byte) (fields map[string]any) {
+	fields = map[string]any{}
+	x, err := exif.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return fields
+	}
+	x.Walk(exifWalker(fields))
+	return fields
+}
+
+type exifWalker map[string]any
+
+func (w exifWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	if tag.Format() == tiff.OtherVal {
+		return nil
+	}
+	data, err := tag.MarshalJSON()
+	if err != nil {
+		return nil
+	}
+	var value any
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return nil
+	}
+	if values, ok := value.([]any); ok && len(values) == 1 {
+		value = values[0]
+	}
+	w[string(name)] = value
+	return nil
+}
+
+// dHash computes the difference hash of the image: the image is scaled down
+// to 9x8 gray pixels and each bit tells if a pixel is brighter than its right
+// neighbour. Similar images have hashes with a small hamming distance.
+func dHash(img image.Image) (hash uint64) {
+	var gray [8][9]float64
+
+	b := img.Bounds()
+	for y := range 8 {
+		y0 := b.Min.Y + y*b.Dy()/8
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/8, y0+1)
+		for x := range 9 {
+			x0 := b.Min.X + x*b.Dx()/9
+			x1 := max(b.Min.X+(x+1)*b.Dx()/9, x0+1)
+			// sample at most 16x16 pixels per cell, large images would be slow
+			stepY := max((y1-y0)/16, 1)
+			stepX := max((x1-x0)/16, 1)
+			sum, n := 0.0, 0
+			for py := y0; py < y1 && py < b.Max.Y; py += stepY {
+				for px := x0; px < x1 && px < b.Max.X; px += stepX {
+					sum += float64(color.Gray16Model.Convert(img.At(px, py)).(color.Gray16).Y)
+					n++
+				}
+			}
+			if n > 0 {
+				gray[y][x] = sum / float64(n)
+			}
+		}
+	}
+
+	for y := range 8 {
+		for x := range 8 {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// referenceHash loads the reference image of compare and computes its hash
+func (imf responseFormatImage) referenceHash(manifestDir string) (hash uint64, err error) {
+	pathSpec, err := util.ParsePathSpec(imf.Compare)
+	if err != nil {
+		return 0, fmt.Errorf("image compare %q: %w", imf.Compare, err)
+	}
+	file, err := util.OpenFileOrUrl(pathSpec.Path, manifestDir)
+	if err != nil {
+		return 0, fmt.Errorf("opening reference image %q: %w", pathSpec.Path, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return 0, fmt.Errorf("reading reference image %q: %w", pathSpec.Path, err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("decoding reference image %q: %w", pathSpec.Path, err)
+	}
+	return dHash(img), nil
+}
+
+// image2Json decodes the image and converts its properties into a json
+// string. The pixels are only decoded if the hash is needed.
+func image2Json(raw []byte, imf responseFormatImage, manifestDir string) (jsonStr []byte, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	info := imageInfo{
+		Format:     format,
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		ColorModel: colorModelName(cfg.ColorModel),
+		Exif:       imageExif(raw),
+	}
+
+	if imf.Hash || imf.Compare != "" {
+		img, _, err := image.Decode(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("decoding image: %w", err)
+		}
+		hash := dHash(img)
+		if imf.Hash {
+			info.DHash = fmt.Sprintf("%016x", hash)
+		}
+		if imf.Compare != "" {
+			refHash, err := imf.referenceHash(manifestDir)
+			if err != nil {
+				return nil, err
+			}
+			distance := bits.OnesCount64(hash ^ refHash)
+			info.Compare = &imageCompare{
+				Distance: distance,
+				Match:    distance <= imf.Tolerance,
+			}
+		}
+	}
+
+	return jsutil.Marshal(info)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/programmfabrik/apitest/pkg/lib/filesystem"
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/spf13/afero"
+	"github.com/tidwall/gjson"
+)
+
+// testImage is a horizontal gradient with a dark square
+func testImage(width, height int) (img *image.NRGBA) {
+	img = image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			v := uint8(x * 255 / width)
+			if x > width/4 && x < width/2 && y > height/4 && y < height/2 {
+				v = 10
+			}
+			img.Set(x, y, color.NRGBA{v, v, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func imageResponse(t *testing.T, raw []byte, imf responseFormatImage, manifestDir string) (jsonStr string) {
+	t.Helper()
+	response := Response{
+		StatusCode: new(http.StatusOK),
+		Body:       raw,
+		Format: ResponseFormat{
+			Type:        responseTypeImage,
+			Image:       imf,
+			ManifestDir: manifestDir,
+		},
+	}
+	jsonStr, err := response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	return jsonStr
+}
+
+func TestImage_Formats(t *testing.T) {
+	img := testImage(120, 80)
+
+	pngBuf := &bytes.Buffer{}
+	err := png.Encode(pngBuf, img)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	jpegBuf := &bytes.Buffer{}
+	err = jpeg.Encode(jpegBuf, img, &jpeg.Options{Quality: 80})
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	gifBuf := &bytes.Buffer{}
+	err = gif.Encode(gifBuf, img, nil)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	for _, tc := range []struct {
+		raw        []byte
+		format     string
+		colorModel string
+	}{
+		{pngBuf.Bytes(), "png", "rgba"},
+		{jpegBuf.Bytes(), "jpeg", "ycbcr"},
+		{gifBuf.Bytes(), "gif", "paletted"},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			jsonStr := imageResponse(t, tc.raw, responseFormatImage{}, "")
+			go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "format").String(), tc.format)
+			go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "width").Int()), 120)
+			go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "height").Int()), 80)
+			go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "color_model").String(), tc.colorModel)
+			go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "exif").Raw, "{}")
+			if gjson.Get(jsonStr, "dhash").Exists() {
+				t.Errorf("dhash without hash: %s", jsonStr)
+			}
+		})
+	}
+
+	response := Response{
+		StatusCode: new(http.StatusOK),
+		Body:       []byte("no image"),
+		Format:     ResponseFormat{Type: responseTypeImage},
+	}
+	_, err = response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectError(t, err, "invalid image did not fail")
+}
+
+func TestImage_HashCompare(t *testing.T) {
+	dir := "/manifest"
+	filesystem.Fs = afero.NewMemMapFs()
+	pngBuf := &bytes.Buffer{}
+	err := png.Encode(pngBuf, testImage(300, 200))
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	err = afero.WriteFile(filesystem.Fs, "/manifest/reference.png", pngBuf.Bytes(), 0644)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	// the same image, resized and encoded lossy
+	jpegBuf := &bytes.Buffer{}
+	err = jpeg.Encode(jpegBuf, testImage(150, 100), &jpeg.Options{Quality: 60})
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	pngJSON := imageResponse(t, pngBuf.Bytes(), responseFormatImage{Hash: true}, dir)
+	jpegJSON := imageResponse(t, jpegBuf.Bytes(), responseFormatImage{Hash: true, Compare: "@reference.png", Tolerance: 5}, dir)
+	go_test_utils.AssertIntEquals(t, len(gjson.Get(pngJSON, "dhash").String()), 16)
+	if gjson.Get(jpegJSON, "compare.distance").Int() > 5 || !gjson.Get(jpegJSON, "compare.match").Bool() {
+		t.Errorf("resized image does not match: %s", jpegJSON)
+	}
+
+	// a different image
+	other := image.NewGray(image.Rect(0, 0, 150, 100))
+	for y := range 100 {
+		for x := range 150 {
+			other.SetGray(x, y, color.Gray{uint8(255 - y*2)})
+			if (x/15+y/10)%2 == 0 {
+				other.SetGray(x, y, color.Gray{0})
+			}
+		}
+	}
+	otherBuf := &bytes.Buffer{}
+	err = png.Encode(otherBuf, other)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	otherJSON := imageResponse(t, otherBuf.Bytes(), responseFormatImage{Compare: "@reference.png", Tolerance: 5}, dir)
+	go_test_utils.AssertStringEquals(t, gjson.Get(otherJSON, "color_model").String(), "gray")
+	if gjson.Get(otherJSON, "compare.match").Bool() {
+		t.Errorf("different image matches: %s", otherJSON)
+	}
+
+	response := Response{
+		StatusCode: new(http.StatusOK),
+		Body:       pngBuf.Bytes(),
+		Format: ResponseFormat{
+			Type:        responseTypeImage,
+			Image:       responseFormatImage{Compare: "@missing.png"},
+			ManifestDir: dir,
+		},
+	}
+	_, err = response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectError(t, err, "missing reference image did not fail")
+}
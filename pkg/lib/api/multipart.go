@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+)
+
+type responseFormatMultipart struct {
+	// Parts converts the body of the part with the same index with the
+	// format, the bodies of the other parts are parsed as json
+	Parts []ResponseFormat `json:"parts,omitempty"`
+}
+
+// multipartBoundary returns the boundary of the multipart Content-Type in
+// the headers of the response
+func multipartBoundary(headers map[string]any) (boundary string, err error) {
+	contentType := ""
+	for key, value := range headers {
+		values, ok := value.([]string)
+		if ok && len(values) > 0 && strings.EqualFold(key, "Content-Type") {
+			contentType = values[0]
+			break
+		}
+	}
+	if contentType == "" {
+		return "", fmt.Errorf("multipart response has no Content-Type")
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("parsing Content-Type %q: %w", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return "", fmt.Errorf("Content-Type %q is not multipart with a boundary", contentType)
+	}
+	return params["boundary"], nil
+}
+
+// multipart2Json splits the body into its parts. Each part has the keys
+// "header", "header_flat" and "body" like a response, the body is converted
+// with the format of the part.
+func multipart2Json(raw []byte, headers map[string]any, mf responseFormatMultipart, manifestDir string) (jsonStr []byte, err error) {
+	boundary, err := multipartBoundary(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []any{}
+	mr := multipart.NewReader(bytes.NewReader(raw), boundary)
+	for idx := 0; ; idx++ {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading part %d: %w", idx, err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("reading part %d: %w", idx, err)
+		}
+
+		var format ResponseFormat
+		if idx < len(mf.Parts) {
+			format = mf.Parts[idx]
+		}
+		format.ManifestDir = manifestDir
+		partResp := Response{
+			Headers: mimeHeaderToMap(part.Header),
+			Body:    data,
+		}
+		partJSON, err := partResp.ServerResponseToGenericJSON(format, false)
+		if err != nil {
+			return nil, fmt.Errorf("parsing part %d: %w", idx, err)
+		}
+		parts = append(parts, partJSON)
+	}
+
+	return jsutil.Marshal(parts)
+}
+
+func mimeHeaderToMap(header textproto.MIMEHeader) (headers map[string]any) {
+	headers = map[string]any{}
+	for k, h := range header {
+		headers[k] = h
+	}
+	return headers
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"testing"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/tidwall/gjson"
+)
+
+func TestMultipart(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"application/json", `{"id":1}`},
+		{"text/plain", "hello"},
+		{"application/json", ""},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		w.Write([]byte(part.body))
+	}
+	err := mw.Close()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	response := Response{
+		StatusCode: new(http.StatusOK),
+		Headers:    map[string]any{"Content-Type": []string{"multipart/mixed; boundary=" + mw.Boundary()}},
+		Body:       buf.Bytes(),
+		Format: ResponseFormat{
+			Type: responseTypeMultipart,
+			Multipart: responseFormatMultipart{
+				Parts: []ResponseFormat{{}, {Type: responseTypeText}},
+			},
+		},
+	}
+	jsonStr, err := response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "#").String(), "3")
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "0.header_flat.Content-Type").String(), "application/json")
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "0.header.Content-Type.0").String(), "application/json")
+	go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "0.body.id").Int()), 1)
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "1.body.text").String(), "hello")
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "2.body").Raw, "null")
+
+	// the second part is not json
+	response.Format.Multipart.Parts = nil
+	_, err = response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectError(t, err, "text part parsed as json did not fail")
+
+	response.Headers = map[string]any{"Content-Type": []string{"application/json"}}
+	_, err = response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectError(t, err, "response without multipart Content-Type did not fail")
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+)
+
+// ndjson2Json parses newline delimited json (JSON Lines) into a json array
+// with one element per line. Empty lines are skipped, line numbers in errors
+// count all lines of the body, starting with 1.
+func ndjson2Json(raw []byte) (jsonStr []byte, err error) {
+	values := jsutil.Array{}
+	for idx, line := range bytes.Split(raw, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			// the decoder stops after the first value, only the complete
+			// unmarshal reports what is wrong with the whole line
+			err = json.Unmarshal(line, new(any))
+			return nil, fmt.Errorf("line %d: %w", idx+1, err)
+		}
+		var v any
+		err = jsutil.UnmarshalPlain(line, &v)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", idx+1, err)
+		}
+		values = append(values, v)
+	}
+
+	return jsutil.Marshal(values)
+}
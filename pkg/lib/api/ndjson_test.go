@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+)
+
+func TestNdjson_Parse(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		json string
+	}{
+		{"{\"a\":1}\n{\"a\":2}\n", `[{"a":1},{"a":2}]`},
+		{"{\"a\":1}\r\n\r\n  [1,2]  \r\n\"s\"", `[{"a":1},[1,2],"s"]`},
+		{"", `[]`},
+	} {
+		jsonBytes, err := ndjson2Json([]byte(tc.raw))
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		go_test_utils.AssertStringEquals(t, string(jsonBytes), tc.json)
+	}
+}
+
+func TestNdjson_ParseError(t *testing.T) {
+	for _, tc := range []struct {
+		raw string
+		err string
+	}{
+		{"{\"a\":1}\n\n{\"a\":\n", "line 3: unexpected end of JSON input"},
+		{"{\"a\":1}\n\n{\"a\":2} {\"a\":3}\n", "line 3: invalid character '{' after top-level value"},
+		{"{\"a\":1}\n\n{\"a\":2}}\n", "line 3: invalid character '}' after top-level value"},
+		{"{\"a\":1}\n\n{\"a\":tru}\n", "line 3: invalid character '}' in literal true (expecting 'e')"},
+	} {
+		_, err := ndjson2Json([]byte(tc.raw))
+		go_test_utils.ExpectError(t, err, "ndjson2Json did not fail on invalid line")
+		go_test_utils.AssertStringEquals(t, err.Error(), tc.err)
+	}
+}
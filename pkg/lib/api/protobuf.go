@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	"github.com/programmfabrik/apitest/pkg/lib/util"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// protobufMessage names a message type of a compiled FileDescriptorSet. It
+// is used for the response format "protobuf" and for body_type "protobuf".
+type protobufMessage struct {
+	Protoset string `yaml:"protoset" json:"protoset"` // compiled FileDescriptorSet, see loadProtoset
+	Message  string `yaml:"message" json:"message"`   // fully qualified message name, like "grpc.health.v1.HealthCheckRequest"
+}
+
+// descriptor loads the protoset and finds the message type
+func (pm protobufMessage) descriptor(manifestDir string) (md protoreflect.MessageDescriptor, files *protoregistry.Files, err error) {
+	if pm.Protoset == "" || pm.Message == "" {
+		return nil, nil, fmt.Errorf("protobuf needs protoset and message")
+	}
+	files, err = loadProtoset(pm.Protoset, manifestDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(pm.Message))
+	if err != nil {
+		return nil, nil, fmt.Errorf("finding message %q: %w", pm.Message, err)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("%q is not a message", pm.Message)
+	}
+	return md, files, nil
+}
+
+// protoJSONOptions renders messages with the field names of the .proto file
+// and all fields, also if they have the default value
+func protoJSONOptions(files *protoregistry.Files) protojson.MarshalOptions {
+	return protojson.MarshalOptions{
+		UseProtoNames:   true,
+		EmitUnpopulated: true,
+		Resolver:        dynamicpb.NewTypes(files),
+	}
+}
+
+// protobuf2Json decodes the raw protobuf message and converts it into a json
+// string, using the protobuf JSON mapping
+func protobuf2Json(raw []byte, pm protobufMessage, manifestDir string) (jsonStr []byte, err error) {
+	md, files, err := pm.descriptor(manifestDir)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	err = proto.UnmarshalOptions{Resolver: dynamicpb.NewTypes(files)}.Unmarshal(raw, msg)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling %q: %w", pm.Message, err)
+	}
+	return protoJSONOptions(files).Marshal(msg)
+}
+
+// protobufBody converts the body of the request, given in protobuf JSON
+// mapping, into the message of body_protobuf
+func (request Request) protobufBody() (msg *dynamicpb.Message, err error) {
+	md, files, err := request.BodyProtobuf.descriptor(request.ManifestDir)
+	if err != nil {
+		return nil, err
+	}
+	msg = dynamicpb.NewMessage(md)
+	if request.Body == nil {
+		return msg, nil
+	}
+	bodyBytes, err := jsutil.Marshal(request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request body: %w", err)
+	}
+	err = protojson.UnmarshalOptions{Resolver: dynamicpb.NewTypes(files)}.Unmarshal(bodyBytes, msg)
+	if err != nil {
+		return nil, fmt.Errorf("converting request body to %q: %w", md.FullName(), err)
+	}
+	return msg, nil
+}
+
+// buildProtobuf sends the body encoded as protobuf message
+func buildProtobuf(request Request) (additionalHeaders map[string]string, body io.Reader, err error) {
+	msg, err := request.protobufBody()
+	if err != nil {
+		return nil, nil, err
+	}
+	bodyBytes, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding request body: %w", err)
+	}
+	additionalHeaders = map[string]string{
+		"Content-Type": "application/x-protobuf",
+	}
+	return additionalHeaders, bytes.NewReader(bodyBytes), nil
+}
+
+// protobufCurlInput renders the body in protobuf text format and the protoc
+// command which encodes it, to be piped into curl
+func (request Request) protobufCurlInput() (input string, err error) {
+	msg, err := request.protobufBody()
+	if err != nil {
+		return "", err
+	}
+	text, err := prototext.MarshalOptions{}.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("encoding request body as text: %w", err)
+	}
+	return fmt.Sprintf("printf '%%s' %s | protoc --descriptor_set_in=%s --encode=%s",
+		curlEscape(string(text)),
+		curlEscape(util.LocalPath(protosetPath(request.BodyProtobuf.Protoset), request.ManifestDir)),
+		request.BodyProtobuf.Message,
+	), nil
+}
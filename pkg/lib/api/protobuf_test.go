@@ -0,0 +1,106 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/programmfabrik/apitest/pkg/lib/filesystem"
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/spf13/afero"
+	"github.com/tidwall/gjson"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// writeHealthProtoset writes the protoset of the gRPC health service into
+// the test filesystem
+func writeHealthProtoset(t *testing.T, dir string) {
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto),
+		},
+	}
+	data, err := proto.Marshal(fds)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	filesystem.Fs = afero.NewMemMapFs()
+	err = afero.WriteFile(filesystem.Fs, filepath.Join(dir, "health.protoset"), data, 0644)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+}
+
+func TestProtobuf_Response(t *testing.T) {
+	dir := "test/"
+	writeHealthProtoset(t, dir)
+
+	raw, err := proto.Marshal(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	pm := protobufMessage{
+		Protoset: "@health.protoset",
+		Message:  "grpc.health.v1.HealthCheckResponse",
+	}
+	jsonBytes, err := protobuf2Json(raw, pm, dir)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, gjson.GetBytes(jsonBytes, "status").String(), "SERVING")
+
+	pm.Message = "grpc.health.v1.Health"
+	_, err = protobuf2Json(raw, pm, dir)
+	go_test_utils.ExpectError(t, err, "protobuf2Json did not fail on a service name")
+
+	pm.Protoset = ""
+	_, err = protobuf2Json(raw, pm, dir)
+	go_test_utils.ExpectError(t, err, "protobuf2Json did not fail without protoset")
+}
+
+func TestProtobuf_RequestBody(t *testing.T) {
+	dir := "test/"
+	writeHealthProtoset(t, dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	pm := protobufMessage{
+		Protoset: "@health.protoset",
+		Message:  "grpc.health.v1.HealthCheckRequest",
+	}
+	request := Request{
+		ServerURL:    ts.URL,
+		Method:       "POST",
+		ManifestDir:  dir,
+		BodyType:     "protobuf",
+		BodyProtobuf: pm,
+		Body:         map[string]any{"service": "apitest"},
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	var msg healthpb.HealthCheckRequest
+	err = proto.Unmarshal(response.Body, &msg)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, msg.Service, "apitest")
+
+	response.Format = ResponseFormat{Type: responseTypeProtobuf, Protobuf: pm, ManifestDir: dir}
+	jsonStr, err := response.ServerResponseToJsonString(false)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "body.service").String(), "apitest")
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "header.Content-Type.0").String(), "application/x-protobuf")
+
+	curl := request.ToString(true)
+	if !strings.HasPrefix(curl, "printf '%s' ") ||
+		!strings.Contains(curl, " | protoc --descriptor_set_in='"+filepath.Join(dir, "health.protoset")+"' --encode=grpc.health.v1.HealthCheckRequest | curl ") ||
+		!strings.Contains(curl, " --data-binary @-") {
+		t.Errorf("unexpected curl command %s", curl)
+	}
+
+	request.Body = map[string]any{"unknown": true}
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail on unknown field")
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxyDirect as proxy of a request disables the proxy of the environment
+// and the config
+const proxyDirect = "direct"
+
+// proxyFunc returns the proxy function of the transport for the request.
+// Credentials in the url are used for the Proxy-Authorization header or
+// the SOCKS5 authentication.
+func (request Request) proxyFunc() (proxy func(*http.Request) (*url.URL, error), err error) {
+	switch request.Proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case proxyDirect:
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(request.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy %q: %w", request.Proxy, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %q: unsupported scheme %q", request.Proxy, proxyURL.Scheme)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+// curlProxyOption returns the curl option for the proxy of the request, ""
+// if the proxy of the environment or none is used
+func (request Request) curlProxyOption() (opt string) {
+	if _, isUnix := request.unixSocket(); isUnix {
+		return ""
+	}
+	switch request.Proxy {
+	case "":
+		return ""
+	case proxyDirect:
+		return "--noproxy '*' "
+	}
+	return "--proxy " + curlEscape(request.Proxy) + " "
+}
+
+// dialFunc dials like net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (dial dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return dial(context.Background(), network, addr)
+}
+
+func (dial dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dial(ctx, network, addr)
+}
+
+// bufferedConn reads the bytes which were buffered while reading the
+// answer of the proxy first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (bc bufferedConn) Read(p []byte) (n int, err error) {
+	return bc.r.Read(p)
+}
+
+// dialProxy connects to addr through the proxy, for connections which are
+// not made by the http transport, like the ones of gRPC requests. The proxy
+// itself is dialed with dial.
+func dialProxy(ctx context.Context, dial dialFunc, proxyURL *url.URL, addr string) (conn net.Conn, err error) {
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dial)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %w", proxyURL.Redacted(), err)
+		}
+		return socks.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	}
+
+	// a http or https proxy opens a tunnel with CONNECT
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err = dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	deadline, ok := ctx.Deadline()
+	if ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	err = connectReq.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %q: CONNECT %s: %s", proxyURL.Redacted(), addr, resp.Status)
+	}
+	return bufferedConn{Conn: conn, r: br}, nil
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+)
+
+func TestProxy_HTTP(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"url":%q,"auth":%q}`, r.URL.String(), r.Header.Get("Proxy-Authorization"))
+	}))
+	defer proxy.Close()
+
+	request := Request{
+		ServerURL: "http://apitest.invalid",
+		Endpoint:  "path",
+		Method:    "GET",
+		Proxy:     strings.Replace(proxy.URL, "http://", "http://user:pass@", 1),
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	// base64 of "user:pass"
+	go_test_utils.AssertStringEquals(t, string(response.Body), `{"url":"http://apitest.invalid/path","auth":"Basic dXNlcjpwYXNz"}`)
+
+	exp := `curl --proxy '` + request.Proxy + `' -X 'GET' -d '' -H 'Content-Type: application/json' -H 'User-Agent: ' 'http://apitest.invalid/path'`
+	go_test_utils.AssertStringEquals(t, request.ToString(true), exp)
+
+	request.Proxy = "ftp://localhost"
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail on unsupported proxy scheme")
+}
+
+func TestProxy_Direct(t *testing.T) {
+	proxy, err := Request{Proxy: proxyDirect}.proxyFunc()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	if proxy != nil {
+		t.Error("expected no proxy function for direct")
+	}
+}
+
+func TestProxy_CookieJar(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sess", Value: "proxied"})
+		fmt.Fprintf(w, `{"cookie":%q}`, r.Header.Get("Cookie"))
+	}))
+	defer proxy.Close()
+
+	jar, err := cookiejar.New(nil)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	request := Request{
+		ServerURL: "http://apitest.invalid",
+		Method:    "GET",
+		Proxy:     proxy.URL,
+		CookieJar: jar,
+	}
+	for _, exp := range []string{`{"cookie":""}`, `{"cookie":"sess=proxied"}`} {
+		response, err := request.Send()
+		go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+		go_test_utils.AssertStringEquals(t, string(response.Body), exp)
+	}
+}
+
+func TestProxy_SOCKS5(t *testing.T) {
+	ts := echoHostServer()
+	ts.Start()
+	defer ts.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	defer lis.Close()
+	go serveSOCKS5(lis, "user", "pass")
+
+	request := Request{
+		ServerURL: ts.URL,
+		Endpoint:  "socks",
+		Method:    "GET",
+		Proxy:     "socks5://user:pass@" + lis.Addr().String(),
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, string(response.Body), `{"host":"`+ts.Listener.Addr().String()+`","path":"/socks"}`)
+
+	request.Proxy = "socks5://user:wrong@" + lis.Addr().String()
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail on wrong SOCKS5 password")
+}
+
+// serveSOCKS5 is a minimal SOCKS5 server with username/password
+// authentication (RFC 1928, RFC 1929) which only supports CONNECT
+func serveSOCKS5(lis net.Listener, username, password string) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			buf := make([]byte, 512)
+			// greeting: version, methods
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+				return
+			}
+			conn.Write([]byte{5, 2})
+			// username/password
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+			user := make([]byte, buf[1])
+			io.ReadFull(conn, user)
+			io.ReadFull(conn, buf[:1])
+			pass := make([]byte, buf[0])
+			io.ReadFull(conn, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+			// connect request: version, cmd, reserved, address type
+			if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+				return
+			}
+			var host string
+			switch buf[3] {
+			case 1:
+				io.ReadFull(conn, buf[:4])
+				host = net.IP(buf[:4]).String()
+			case 3:
+				io.ReadFull(conn, buf[:1])
+				name := make([]byte, buf[0])
+				io.ReadFull(conn, name)
+				host = string(name)
+			default:
+				return
+			}
+			io.ReadFull(conn, buf[:2])
+			port := binary.BigEndian.Uint16(buf[:2])
+			target, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprint(port)))
+			if err != nil {
+				conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer target.Close()
+			conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+			go io.Copy(target, conn)
+			io.Copy(conn, target)
+		}()
+	}
+}
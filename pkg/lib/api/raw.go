@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// rawRequest writes the literal request bytes to a TCP connection, so also
+// invalid or exotic requests can be sent. The server_url is the target:
+// "http://" (and no scheme) connect in plaintext, "https://" uses TLS.
+type rawRequest struct {
+	Request   string `yaml:"request" json:"request"`       // the literal request, like "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	CRLF      bool   `yaml:"crlf" json:"crlf"`             // replace the "\n" line endings of the request with "\r\n"
+	TimeoutMS int    `yaml:"timeout_ms" json:"timeout_ms"` // timeout for the whole exchange, default is the timeout of the request or 30000
+}
+
+// rawTarget returns the address to connect to and if TLS is used
+func rawTarget(serverURL string) (addr string, useTLS bool, err error) {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "http://" + serverURL
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", false, fmt.Errorf("parsing server_url %q: %w", serverURL, err)
+	}
+
+	port := u.Port()
+	switch u.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		useTLS = true
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return "", false, fmt.Errorf("invalid scheme %q for raw request, allowed: http, https", u.Scheme)
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// data returns the bytes to send
+func (rr rawRequest) data() (data []byte) {
+	if !rr.CRLF {
+		return []byte(rr.Request)
+	}
+	lf := strings.ReplaceAll(rr.Request, "\r\n", "\n")
+	return []byte(strings.ReplaceAll(lf, "\n", "\r\n"))
+}
+
+// rawIdleTimeout ends reading an answer which is not a HTTP response, if no
+// more data is received
+const rawIdleTimeout = 500 * time.Millisecond
+
+// sendRaw writes the raw request and parses the answer as HTTP response. If
+// the answer can't be parsed, everything that was received until the
+// connection was closed, no more data was received for rawIdleTimeout, or
+// the timeout, is the body and the status code is 0.
+func (request Request) sendRaw() (response Response, err error) {
+	rr := request.Raw
+
+	// the request is sent as it is, it can't be authenticated
+	if request.Auth != nil && request.Auth.Type != authTypeNone {
+		return response, fmt.Errorf("auth %q is not supported for raw requests", request.Auth.Type)
+	}
+
+	addr, useTLS, err := rawTarget(request.ServerURL)
+	if err != nil {
+		return response, err
+	}
+
+	timeout := 30 * time.Second
+	if rr.TimeoutMS > 0 {
+		timeout = time.Duration(rr.TimeoutMS) * time.Millisecond
+	} else if request.TimeoutMS > 0 {
+		timeout = time.Duration(request.TimeoutMS) * time.Millisecond
+	}
+
+	now := time.Now()
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return request.rawExpectedErrorResponse(fmt.Errorf("connecting to %q: %w", addr, err), now)
+	}
+	defer conn.Close()
+
+	deadline := now.Add(timeout)
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return response, fmt.Errorf("setting deadline: %w", err)
+	}
+
+	data := rr.data()
+	_, err = conn.Write(data)
+	if err != nil {
+		return request.rawExpectedErrorResponse(fmt.Errorf("writing raw request: %w", err), now)
+	}
+
+	// the method is needed to know if the response has a body (HEAD)
+	method, _, _ := strings.Cut(string(data), " ")
+
+	var received bytes.Buffer
+	br := bufio.NewReader(io.TeeReader(conn, &received))
+	var (
+		httpResponse *http.Response
+		body         []byte
+	)
+	// an answer which does not start like a status line is clearly not
+	// HTTP, it is not parsed to not wait for the end of the line
+	_, err = br.Peek(1)
+	if err == nil {
+		start, _ := br.Peek(min(br.Buffered(), len("HTTP/")))
+		if !strings.HasPrefix("HTTP/", string(start)) {
+			err = fmt.Errorf("answer is not a HTTP response")
+		}
+	}
+	if err == nil {
+		httpResponse, err = http.ReadResponse(br, &http.Request{Method: method})
+	}
+	if err == nil {
+		body, err = io.ReadAll(httpResponse.Body)
+		httpResponse.Body.Close()
+	}
+	elapsedTime := time.Since(now)
+
+	if err != nil {
+		// not a valid response, use the raw bytes
+		readRawRest(conn, br, deadline)
+		if received.Len() == 0 {
+			return request.rawExpectedErrorResponse(fmt.Errorf("reading raw response: %w", err), now)
+		}
+		if request.ExpectError != "" {
+			return response, fmt.Errorf("expected error %q, got %d bytes", request.ExpectError, received.Len())
+		}
+		response, err = NewResponse(new(0), nil, nil, bytes.NewReader(received.Bytes()), nil, ResponseFormat{})
+		if err != nil {
+			return response, fmt.Errorf("constructing response from raw response: %w", err)
+		}
+		response.ReqDur = time.Since(now)
+		return response, nil
+	}
+
+	if request.ExpectError != "" {
+		return response, fmt.Errorf("expected error %q, got a response with statuscode %d", request.ExpectError, httpResponse.StatusCode)
+	}
+	header, err := httpHeaderToMap(httpResponse.Header)
+	if err != nil {
+		return response, err
+	}
+	response, err = NewResponse(new(httpResponse.StatusCode), header, httpResponse.Cookies(), bytes.NewReader(body), nil, ResponseFormat{})
+	if err != nil {
+		return response, fmt.Errorf("constructing response from raw response: %w", err)
+	}
+	response.ReqDur = elapsedTime
+	return response, nil
+}
+
+// readRawRest reads the rest of an answer which is not a HTTP response. As
+// the length of such an answer is unknown, reading ends when the server
+// closes the connection or sends no more data for rawIdleTimeout.
+func readRawRest(conn net.Conn, br *bufio.Reader, deadline time.Time) {
+	buf := make([]byte, 4096)
+	for {
+		idle := time.Now().Add(rawIdleTimeout)
+		if idle.After(deadline) {
+			idle = deadline
+		}
+		err := conn.SetReadDeadline(idle)
+		if err != nil {
+			return
+		}
+		_, err = br.Read(buf)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (request Request) rawExpectedErrorResponse(err error, start time.Time) (response Response, errOut error) {
+	response, err = request.expectedErrorResponse(err)
+	if err != nil {
+		return response, err
+	}
+	response.ReqDur = time.Since(start)
+	return response, nil
+}
+
+// rawToString returns the literal request. The curl variant is a command
+// sending the request with nc, or openssl for TLS.
+func (request Request) rawToString(curl bool) (res string) {
+	data := request.Raw.data()
+	if !curl {
+		return string(data)
+	}
+
+	addr, useTLS, err := rawTarget(request.ServerURL)
+	if err != nil {
+		return fmt.Sprintf("could not build raw request: %s", err.Error())
+	}
+	if useTLS {
+		return fmt.Sprintf("printf '%%s' %s | openssl s_client -quiet -connect %s", curlEscape(string(data)), curlEscape(addr))
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	return fmt.Sprintf("printf '%%s' %s | nc %s %s", curlEscape(string(data)), curlEscape(host), port)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+)
+
+func TestRaw_Send(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		fmt.Fprint(w, `{"path":"`+r.URL.Path+`"}`)
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name   string
+		raw    rawRequest
+		status int
+		body   string
+	}{
+		{
+			"crlf",
+			rawRequest{Request: "GET /x HTTP/1.1\nHost: localhost\n\n", CRLF: true},
+			200, `{"path":"/x"}`,
+		},
+		{
+			"non-standard method",
+			rawRequest{Request: "PURGE /y HTTP/1.1\r\nHost: localhost\r\n\r\n"},
+			200, `{"path":"/y"}`,
+		},
+		{
+			"duplicate content-length",
+			rawRequest{Request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab"},
+			400, "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := Request{
+				ServerURL: ts.URL,
+				Raw:       &tc.raw,
+			}
+			response, err := request.Send()
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertIntEquals(t, *response.StatusCode, tc.status)
+			if tc.body != "" {
+				go_test_utils.AssertStringEquals(t, string(response.Body), tc.body)
+			}
+		})
+	}
+
+	request := Request{
+		ServerURL: ts.URL,
+		Raw:       &rawRequest{Request: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"},
+		Auth:      &RequestAuth{Type: authTypeBasic},
+	}
+	_, err := request.Send()
+	go_test_utils.ExpectError(t, err, "auth did not fail for raw request")
+}
+
+func TestRaw_Unparsable(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		answer string
+		close  bool
+	}{
+		{"closed", "not http\n", true},
+		// the server keeps the connection open, reading must not wait for
+		// the timeout
+		{"open", "not http\n", false},
+		{"open without line end", "not http", false},
+		{"invalid status line", "HTTP/1.1 abc\r\n", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			defer lis.Close()
+			go func() {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				conn.Read(buf)
+				conn.Write([]byte(tc.answer))
+				if !tc.close {
+					// wait until the client closes the connection
+					conn.Read(buf)
+				}
+			}()
+
+			request := Request{
+				ServerURL: lis.Addr().String(),
+				Raw: &rawRequest{
+					Request: "HELLO\r\n\r\n",
+				},
+			}
+			start := time.Now()
+			response, err := request.Send()
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertIntEquals(t, *response.StatusCode, 0)
+			go_test_utils.AssertStringEquals(t, string(response.Body), tc.answer)
+			if time.Since(start) > 5*time.Second {
+				t.Errorf("reading the answer took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestRaw_Timeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// never answer
+		buf := make([]byte, 1024)
+		for {
+			_, err = conn.Read(buf)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	request := Request{
+		ServerURL: lis.Addr().String(),
+		TimeoutMS: 200,
+		Raw: &rawRequest{
+			Request: "GET / HTTP/1.1\r\n\r\n",
+		},
+	}
+	start := time.Now()
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail without an answer")
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("timeout_ms of the request was not used, took %s", time.Since(start))
+	}
+}
+
+func TestRaw_ToString(t *testing.T) {
+	request := Request{
+		ServerURL: "http://localhost:8080",
+		Raw: &rawRequest{
+			Request: "GET / HTTP/1.1\nHost: it's\n\n",
+			CRLF:    true,
+		},
+	}
+	go_test_utils.AssertStringEquals(t, request.ToString(false), "GET / HTTP/1.1\r\nHost: it's\r\n\r\n")
+	go_test_utils.AssertStringEquals(t, request.ToString(true), `printf '%s' 'GET / HTTP/1.1`+"\r\n"+`Host: it'\''s`+"\r\n\r\n"+`' | nc 'localhost' 8080`)
+
+	request.ServerURL = "https://localhost"
+	if !strings.HasSuffix(request.ToString(true), "| openssl s_client -quiet -connect 'localhost:443'") {
+		t.Errorf("expected openssl command, got %s", request.ToString(true))
+	}
+}
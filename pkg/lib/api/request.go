@@ -1,9 +1,11 @@
 package api
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -27,6 +29,8 @@ func init() {
 		},
 		// We need to avoid the automatically set gzip header
 		DisableCompression: true,
+		// HTTP_PROXY, HTTPS_PROXY and NO_PROXY, see Request.Proxy
+		Proxy: http.ProxyFromEnvironment,
 	}
 
 	httpClient = &http.Client{
@@ -40,11 +44,26 @@ type requestCookie struct {
 	Value          string `yaml:"value" json:"value"`
 }
 
+type requestBodyGenerate struct {
+	Size    int64  `yaml:"size" json:"size"`       // size of the body in bytes
+	Pattern string `yaml:"pattern" json:"pattern"` // repeated to fill the body, default is a zero byte
+}
+
 type Request struct {
 	Endpoint             string                    `yaml:"endpoint" json:"endpoint"`
 	ServerURL            string                    `yaml:"server_url" json:"server_url"`
+	// Proxy for the request, overriding the environment: "http://", "https://",
+	// "socks5://" or "socks5h://" url with optional credentials, or "direct"
+	Proxy                string                    `yaml:"proxy" json:"proxy"`
+	// Resolve overrides the address dialed for "host:port" or "host", like curl --resolve
+	Resolve              map[string]string         `yaml:"resolve" json:"resolve"`
 	Method               string                    `yaml:"method" json:"method"`
 	NoRedirect           bool                      `yaml:"no_redirect" json:"no_redirect"`
+	// TimeoutMS limits the whole exchange including reading the body, default 5 minutes
+	TimeoutMS            int                       `yaml:"timeout_ms" json:"timeout_ms"`
+	// ExpectError is the kind of the expected transport error, see transportErrorKind
+	ExpectError          string                    `yaml:"expect_error" json:"expect_error"`
+	MaxRedirects         int                       `yaml:"max_redirects" json:"max_redirects"` // default 10
 	QueryParams          map[string]any            `yaml:"query_params" json:"query_params"`
 	QueryParamsFromStore map[string]string         `yaml:"query_params_from_store" json:"query_params_from_store"`
 	Headers              map[string]any            `yaml:"header" json:"header"`
@@ -56,9 +75,34 @@ type Request struct {
 	// Set-Cookie responses are stored and replayed honoring Path/Domain/Secure
 	// like a browser, instead of being threaded by hand.
 	CookieJar            http.CookieJar            `yaml:"-" json:"-"`
+	// ResponseFormat is set programmatically from the expected response. It
+	// is needed for formats like "sse" which decide how long the body is read.
+	ResponseFormat       ResponseFormat            `yaml:"-" json:"-"`
+	// AuthTokens is set programmatically, the oauth2 tokens are shared by
+	// all requests of the suite
+	AuthTokens           *AuthTokenCache           `yaml:"-" json:"-"`
 	BodyType             string                    `yaml:"body_type" json:"body_type"`
 	BodyFile             string                    `yaml:"body_file" json:"body_file"`
+	BodyGenerate         requestBodyGenerate       `yaml:"body_generate" json:"body_generate"` // ignored if body_type != "generate"
+	BodyProtobuf         protobufMessage           `yaml:"body_protobuf" json:"body_protobuf"` // ignored if body_type != "protobuf"
 	Body                 any                       `yaml:"body" json:"body"`
+	// BodyMultipartType is the multipart subtype: "form-data" (default), "related", "mixed"
+	BodyMultipartType    string                    `yaml:"body_multipart_type" json:"body_multipart_type"`
+	// BodyEncoding compresses the body: "gzip", "deflate", "br", "zstd"
+	BodyEncoding         string                    `yaml:"body_encoding" json:"body_encoding"`
+	// Simulate slow or stalled clients, see throttledBody
+	RateLimitBytesPerSec int64                     `yaml:"rate_limit_bytes_per_sec" json:"rate_limit_bytes_per_sec"`
+	PauseAfterBytes      *int64                    `yaml:"pause_after_bytes" json:"pause_after_bytes"`
+	PauseMS              int                       `yaml:"pause_ms" json:"pause_ms"`
+	AbortAfterBytes      *int64                    `yaml:"abort_after_bytes" json:"abort_after_bytes"`
+	// Auth authenticates the request, see RequestAuth
+	Auth                 *RequestAuth              `yaml:"auth" json:"auth"`
+	// GRPC turns the request into a unary gRPC call, see grpcRequest
+	GRPC                 *grpcRequest              `yaml:"grpc" json:"grpc"`
+	// Raw sends the literal request bytes, see rawRequest
+	Raw                  *rawRequest               `yaml:"raw" json:"raw"`
+	// Jar inspects or changes the cookie jar, see cookieJarRequest
+	Jar                  *cookieJarRequest         `yaml:"cookie_jar" json:"cookie_jar"`
 
 	buildPolicy func(Request) (additionalHeaders map[string]string, body io.Reader, err error)
 	ManifestDir string
@@ -75,15 +119,24 @@ func (request Request) buildHttpRequest() (req *http.Request, err error) {
 			request.buildPolicy = buildUrlencoded
 		case "file":
 			request.buildPolicy = buildFile
+		case "generate":
+			request.buildPolicy = buildGenerate
+		case "protobuf":
+			request.buildPolicy = buildProtobuf
 		default:
 			request.buildPolicy = buildRegular
 		}
 	}
 	// Render Request Url
 
-	requestUrl := fmt.Sprintf("%s/%s", request.ServerURL, request.Endpoint)
+	serverURL := request.ServerURL
+	if _, isUnix := request.unixSocket(); isUnix {
+		// the socket is dialed by the transport, see dialContext
+		serverURL = "http://localhost"
+	}
+	requestUrl := fmt.Sprintf("%s/%s", serverURL, request.Endpoint)
 	if request.Endpoint == "" {
-		requestUrl = request.ServerURL
+		requestUrl = serverURL
 	}
 
 	reqUrl, err := url.Parse(requestUrl)
@@ -99,11 +152,45 @@ func (request Request) buildHttpRequest() (req *http.Request, err error) {
 	if err != nil {
 		return req, fmt.Errorf("executing buildpolicy: %w", err)
 	}
+	// If the request can not be built, the body is closed. This also ends
+	// the encoding of the body.
+	defer func() {
+		if err == nil {
+			return
+		}
+		if closer, ok := body.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+
+	if request.BodyEncoding != "" {
+		encoded, err := encodeContent(request.BodyEncoding, body)
+		if err != nil {
+			return req, err
+		}
+		body = encoded
+	}
 
 	req, err = http.NewRequest(request.Method, requestUrl, body)
 	if err != nil {
 		return req, fmt.Errorf("creating new request: %w", err)
 	}
+	// Streamed bodies of known size are not sent chunked
+	switch b := body.(type) {
+	case *generatedBody:
+		req.ContentLength = b.size
+		if b.size == 0 {
+			req.Body = http.NoBody
+		}
+	case interface{ Stat() (fs.FileInfo, error) }:
+		fi, err := b.Stat()
+		if err == nil && fi.Mode().IsRegular() {
+			req.ContentLength = fi.Size()
+		}
+	}
+	if request.BodyEncoding != "" {
+		req.Header.Set("Content-Encoding", request.BodyEncoding)
+	}
 
 	// Remove library default agent
 	req.Header.Set("User-Agent", "")
@@ -272,19 +359,102 @@ func (request Request) buildHttpRequest() (req *http.Request, err error) {
 		req.Header.Add("X-Test-Set-Cookies", ckVal)
 	}
 
+	// Sign last, the signature covers the final headers and body
+	if request.Auth != nil {
+		var stream *requestBodyStream
+		switch request.BodyType {
+		case "file":
+			// the file is opened again to hash it, the same way it is sent
+			stream = &requestBodyStream{
+				reopen: func() (body io.ReadCloser, err error) {
+					_, file, err := buildFile(request)
+					if err != nil {
+						return nil, err
+					}
+					if request.BodyEncoding != "" {
+						return encodeContent(request.BodyEncoding, file)
+					}
+					closer, ok := file.(io.ReadCloser)
+					if !ok {
+						closer = io.NopCloser(file)
+					}
+					return closer, nil
+				},
+			}
+		case "generate":
+			stream = &requestBodyStream{}
+		}
+		err = request.Auth.apply(req, request.DataStore, request.AuthTokens, stream)
+		if err != nil {
+			return nil, fmt.Errorf("applying auth %q: %w", request.Auth.Type, err)
+		}
+	}
+
 	return req, nil
 }
 
+// closeRequestBody closes the body of a request which is not sent, the
+// transport closes it for a sent request
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
+
+// curlEscape quotes s for the shell like http2curl does
+func curlEscape(s string) (escaped string) {
+	return `'` + strings.ReplaceAll(s, `'`, `'\''`) + `'`
+}
+
+// curlCommand returns the curl command for the http request, with the
+// options for the proxy and to dial a unix socket or resolved address
+func (request Request) curlCommand(httpRequest *http.Request) (cmd string) {
+	curl, _ := http2curl.GetCurlCommand(httpRequest)
+	opts := request.curlProxyOption() + request.curlDialOptions()
+	if request.TimeoutMS > 0 {
+		opts += fmt.Sprintf("--max-time %g ", float64(request.TimeoutMS)/1000)
+	}
+	return strings.Replace(curl.String(), "curl ", "curl "+opts, 1)
+}
+
 func (request Request) ToString(curl bool) (res string) {
+	if request.GRPC != nil {
+		return request.grpcToString(curl)
+	}
+	if request.Raw != nil {
+		return request.rawToString(curl)
+	}
+	if request.Jar != nil {
+		return request.cookieJarToString()
+	}
+
+	// The body is shown uncompressed. The curl command compresses it with
+	// a command line tool, the dump shows the Content-Encoding header.
+	bodyEncoding := request.BodyEncoding
+	request.BodyEncoding = ""
 	httpRequest, err := request.buildHttpRequest()
 	if err != nil {
 		return fmt.Sprintf("could not build httpRequest: %s", err.Error())
 	}
+	if bodyEncoding != "" {
+		httpRequest.Header.Set("Content-Encoding", bodyEncoding)
+		// The sent signature covers the encoded body, the signature of the
+		// uncompressed body would differ
+		if request.Auth != nil {
+			for _, name := range request.Auth.bodySignatureHeaders() {
+				if httpRequest.Header.Get(name) != "" {
+					httpRequest.Header.Set(name, "[signature of the encoded body, not reproducible]")
+				}
+			}
+		}
+	}
 
+	// Files and generated bodies can be big, they are not read for the dump
 	var dumpBody bool
-	if request.BodyType == "multipart" {
+	switch request.BodyType {
+	case "multipart", "file", "generate", "protobuf":
 		dumpBody = false
-	} else {
+	default:
 		dumpBody = true
 	}
 
@@ -292,25 +462,98 @@ func (request Request) ToString(curl bool) (res string) {
 		// Log as curl
 		// r := strings.NewReplacer(" -", " \\\n-", "' '", "' \\\n'")
 
+		if dumpBody && bodyEncoding != "" {
+			body, err := readRequestBody(httpRequest)
+			if err != nil {
+				return fmt.Sprintf("could not read body: %s", err.Error())
+			}
+			bodyEscaped := curlEscape(string(body))
+			return fmt.Sprintf("printf '%%s' %s | %s | %s",
+				bodyEscaped,
+				contentEncodingTools[bodyEncoding],
+				strings.Replace(request.curlCommand(httpRequest), " -d "+bodyEscaped, " --data-binary @-", 1),
+			)
+		}
+
 		if dumpBody {
-			curl, _ := http2curl.GetCurlCommand(httpRequest)
-			return curl.String()
+			return request.curlCommand(httpRequest)
 			// return r.Replace(curl.String())
 		}
 
-		_, _ = io.Copy(io.Discard, httpRequest.Body)
-		_ = httpRequest.Body.Close()
+		// curl -F only builds multipart/form-data, the other subtypes are
+		// sent as the built body with its Content-Type and boundary
+		var multipartBody []byte
+		_, isPartList := request.Body.([]any)
+		if request.BodyType == "multipart" && isPartList && request.multipartType() != "form-data" {
+			multipartBody, err = readRequestBody(httpRequest)
+			if err != nil {
+				return fmt.Sprintf("could not read body: %s", err.Error())
+			}
+		}
+
+		closeRequestBody(httpRequest)
+		httpRequest.Body = http.NoBody
 
-		curl, _ := http2curl.GetCurlCommand(httpRequest)
-		cString := curl.String()
+		cString := request.curlCommand(httpRequest)
 
 		rep := ""
-		for key, val := range request.Body.(map[string]any) {
-			pathSpec, ok := val.(jsutil.String)
-			if !ok {
-				panic(errors.New("pathSpec should be a string"))
+		switch request.BodyType {
+		case "multipart":
+			if multipartBody != nil {
+				input := "printf '%s' " + curlEscape(string(multipartBody))
+				if bodyEncoding != "" {
+					input += " | " + contentEncodingTools[bodyEncoding]
+				}
+				cString = input + " | " + cString
+				rep = " --data-binary @-"
+				break
+			}
+			parts, ok := request.Body.([]any)
+			if ok {
+				rep = request.curlMultipartParts(parts)
+				break
 			}
-			rep = fmt.Sprintf(`%s -F "%s=@%s"`, rep, key, path.Join(request.ManifestDir, pathSpec[1:]))
+			for key, val := range request.Body.(map[string]any) {
+				pathSpec, ok := val.(jsutil.String)
+				if !ok {
+					panic(errors.New("pathSpec should be a string"))
+				}
+				rep = fmt.Sprintf(`%s -F "%s=@%s"`, rep, key, path.Join(request.ManifestDir, pathSpec[1:]))
+			}
+		case "file":
+			filePath := request.BodyFile
+			pathSpec, err := util.ParsePathSpec(request.BodyFile)
+			if err == nil && pathSpec != nil {
+				filePath = pathSpec.Path
+			}
+			localPath := util.LocalPath(filePath, request.ManifestDir)
+			if bodyEncoding != "" {
+				cString = contentEncodingTools[bodyEncoding] + " < " + curlEscape(localPath) + " | " + cString
+				rep = " --data-binary @-"
+			} else {
+				rep = " --data-binary " + curlEscape("@"+localPath)
+			}
+		case "generate":
+			pattern := request.BodyGenerate.Pattern
+			generator := fmt.Sprintf("head -c %d /dev/zero", request.BodyGenerate.Size)
+			if pattern != "" {
+				generator = fmt.Sprintf("yes %s | tr -d '\\n' | head -c %d", curlEscape(pattern), request.BodyGenerate.Size)
+			}
+			if bodyEncoding != "" {
+				generator += " | " + contentEncodingTools[bodyEncoding]
+			}
+			cString = generator + " | " + cString
+			rep = " --data-binary @-"
+		case "protobuf":
+			input, err := request.protobufCurlInput()
+			if err != nil {
+				return fmt.Sprintf("could not build protobuf body: %s", err.Error())
+			}
+			if bodyEncoding != "" {
+				input += " | " + contentEncodingTools[bodyEncoding]
+			}
+			cString = input + " | " + cString
+			rep = " --data-binary @-"
 		}
 		// return r.Replace(strings.Replace(cString, " -d ''", rep, 1))
 		return strings.Replace(cString, " -d ''", rep, 1)
@@ -324,35 +567,99 @@ func (request Request) ToString(curl bool) (res string) {
 }
 
 func (request Request) Send() (response Response, err error) {
+	err = request.checkExpectError()
+	if err != nil {
+		return response, err
+	}
+
+	if request.GRPC != nil {
+		return request.sendGRPC()
+	}
+	if request.Raw != nil {
+		return request.sendRaw()
+	}
+	if request.Jar != nil {
+		return request.sendCookieJar()
+	}
+
 	httpRequest, err := request.buildHttpRequest()
 	if err != nil {
 		return response, fmt.Errorf("could not buildHttpRequest: %w", err)
 	}
 
-	// Default: the shared client, cookies threaded by hand. When the suite opted
-	// into a cookie jar, use a per-request client copy carrying that jar (sharing
-	// the Transport) so Set-Cookie responses are stored and replayed honoring the
-	// cookie Path/Domain/Secure scoping, like a browser.
-	client := httpClient
+	// Each request uses a copy of the shared client (sharing the Transport),
+	// so the redirects can be recorded per request. When the suite opted into
+	// a cookie jar, the copy carries that jar so Set-Cookie responses are
+	// stored and replayed honoring the cookie Path/Domain/Secure scoping,
+	// like a browser. Otherwise cookies are threaded by hand.
+	c := *httpClient
+	client := &c
 	if request.CookieJar != nil {
-		c := *httpClient
-		c.Jar = request.CookieJar
-		client = &c
+		client.Jar = request.CookieJar
+	}
+	if request.TimeoutMS > 0 {
+		client.Timeout = time.Duration(request.TimeoutMS) * time.Millisecond
+	}
+	tr, err := request.transport()
+	if err != nil {
+		closeRequestBody(httpRequest)
+		return response, err
+	}
+	if tr != nil {
+		client.Transport = tr
 	}
 
-	if request.NoRedirect {
-		client.CheckRedirect = func(req *http.Request, via []*http.Request) (err error) {
+	maxRedirects := request.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = 10
+	}
+	redirects := []responseRedirect{}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) (err error) {
+		if request.NoRedirect {
 			return http.ErrUseLastResponse
 		}
-	} else {
-		client.CheckRedirect = nil
+		redirects = append(redirects, newResponseRedirect(req.Response))
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return nil
+	}
+
+	// The body is only throttled when it is sent, not for ToString
+	var tb *throttledBody
+	if request.throttled() && httpRequest.Body != nil && httpRequest.Body != http.NoBody {
+		tb = newThrottledBody(request, httpRequest.Body)
+		httpRequest.Body = tb
+		httpRequest.GetBody = nil
 	}
 
 	now := time.Now()
 
 	httpResponse, err := client.Do(httpRequest)
+	if err != nil && tb != nil && tb.aborted.Load() {
+		// a deliberate abort is part of the response, not an error
+		response, err = NewResponse(new(0), nil, nil, nil, nil, ResponseFormat{})
+		if err != nil {
+			return response, fmt.Errorf("constructing response for aborted request: %w", err)
+		}
+		response.ReqDur = time.Since(now)
+		response.Transfer = tb.transfer(errUploadAborted)
+		return response, nil
+	}
 	if err != nil {
-		return response, fmt.Errorf("could not do http request: %w", err)
+		// an expected transport error is part of the response
+		response, err = request.expectedErrorResponse(err)
+		if err != nil {
+			return response, fmt.Errorf("could not do http request: %w", err)
+		}
+		response.ReqDur = time.Since(now)
+		return response, nil
+	}
+	if request.Auth != nil && request.Auth.Type == authTypeDigest && httpResponse.StatusCode == http.StatusUnauthorized {
+		httpResponse, err = request.digestRoundTrip(client, httpResponse)
+		if err != nil {
+			return response, fmt.Errorf("could not do http request: %w", err)
+		}
 	}
 
 	elapsedTime := time.Since(now)
@@ -363,10 +670,85 @@ func (request Request) Send() (response Response, err error) {
 	if err != nil {
 		return response, err
 	}
-	response, err = NewResponse(new(httpResponse.StatusCode), header, httpResponse.Cookies(), httpResponse.Body, nil, ResponseFormat{})
+
+	// The transport never decodes the body, this is only done on request
+	// of the response format
+	var (
+		bodyReader io.ReadCloser = httpResponse.Body
+		compressed *countingReader
+	)
+	if request.ResponseFormat.Decompress {
+		compressed = &countingReader{r: httpResponse.Body}
+		contentEncoding := strings.Join(httpResponse.Header.Values("Content-Encoding"), ",")
+		dec, err := decodeContent(contentEncoding, compressed)
+		if err != nil {
+			return response, fmt.Errorf("decompressing response: %w", err)
+		}
+		bodyReader = struct {
+			io.Reader
+			io.Closer
+		}{dec, httpResponse.Body}
+	}
+
+	var (
+		body     io.Reader = bodyReader
+		streamed *streamedBody
+	)
+	switch {
+	case request.ResponseFormat.Type == responseTypeSSE:
+		// An event stream is possibly never closed by the server, so it is
+		// only read until the stop condition of the format is met
+		sseData, err := readSSE(bodyReader, request.ResponseFormat.SSE)
+		if err != nil {
+			return response, fmt.Errorf("reading event stream: %w", err)
+		}
+		body = bytes.NewReader(sseData)
+	case request.ResponseFormat.Type == responseTypeBinary && request.ResponseFormat.Binary.Stream:
+		// A big body is only hashed, it is never held in memory
+		streamed, err = streamBody(bodyReader, request.ResponseFormat.Binary.KeepFile)
+		if err != nil {
+			// the timeout or a reset can also happen while streaming
+			response, err = request.expectedErrorResponse(err)
+			if err != nil {
+				return response, err
+			}
+			response.ReqDur = elapsedTime
+			return response, nil
+		}
+		body = nil
+	}
+
+	response, err = NewResponse(new(httpResponse.StatusCode), header, httpResponse.Cookies(), body, nil, ResponseFormat{})
 	if err != nil {
-		return response, fmt.Errorf("constructing response from http response: %w", err)
+		// the timeout or a reset can also happen while reading the body
+		response, err = request.expectedErrorResponse(err)
+		if err != nil {
+			return response, fmt.Errorf("constructing response from http response: %w", err)
+		}
+		response.ReqDur = elapsedTime
+		return response, nil
+	}
+	if request.ExpectError != "" {
+		return response, fmt.Errorf("expected error %q, got a response with statuscode %d", request.ExpectError, httpResponse.StatusCode)
 	}
 	response.ReqDur = elapsedTime
+	response.Streamed = streamed
+	if tb != nil {
+		response.Transfer = tb.transfer(nil)
+	}
+	if len(redirects) > 0 {
+		response.Redirects = redirects
+	}
+	if compressed != nil {
+		decompressedSize := int64(len(response.Body))
+		if streamed != nil {
+			decompressedSize = streamed.Size
+		}
+		response.Encoding = responseEncoding{
+			ContentEncoding:  strings.Join(httpResponse.Header.Values("Content-Encoding"), ", "),
+			CompressedSize:   compressed.n,
+			DecompressedSize: decompressedSize,
+		}
+	}
 	return response, err
 }
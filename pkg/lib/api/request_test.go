@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/programmfabrik/apitest/pkg/lib/datastore"
 	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
 	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/tidwall/gjson"
 )
 
 func TestRequestBuildHttp(t *testing.T) {
@@ -124,3 +126,50 @@ func TestRequestBuildHttpWithCookie(t *testing.T) {
 		}
 	}
 }
+
+func TestRequestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sess", Value: "1"})
+		http.Redirect(w, r, "/step", http.StatusFound)
+	})
+	mux.HandleFunc("/step", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/home", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"home":true}`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	request := Request{
+		ServerURL: ts.URL,
+		Endpoint:  "login",
+		Method:    "GET",
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, *response.StatusCode, 200)
+
+	jsonStr, err := response.ServerResponseToJsonString(false)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "redirects.#").Int()), 2)
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "redirects.0.url").String(), ts.URL+"/login")
+	go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "redirects.0.statuscode").Int()), 302)
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "redirects.0.location").String(), "/step")
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "redirects.0.set_cookie.0").String(), "sess=1")
+	go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "redirects.1.statuscode").Int()), 301)
+
+	request.MaxRedirects = 1
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Expected error for too many redirects")
+
+	request.MaxRedirects = 0
+	request.NoRedirect = true
+	response, err = request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, *response.StatusCode, 302)
+	if response.Redirects != nil {
+		t.Errorf("expected no redirects with no_redirect, got %v", response.Redirects)
+	}
+}
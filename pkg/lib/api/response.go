@@ -3,8 +3,6 @@ package api
 import (
 	"bufio"
 	"bytes"
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,11 +25,51 @@ type Response struct {
 	Body        []byte
 	BodyControl jsutil.Object
 	Format      ResponseFormat
+	// Encoding is the content encoding and the sizes of the body, only set
+	// if the format has "decompress". It is "any" to allow controls in the
+	// expected response.
+	Encoding any
+	// Redirects are the followed redirects, only set if there were any
+	Redirects any
+	// SetCookies are all cookies of the response, in the expected response
+	// it is "any" to allow controls
+	SetCookies any
+	// Transfer is the progress of a throttled request body, only set if the
+	// request has rate_limit_bytes_per_sec, pause_after_bytes or abort_after_bytes
+	Transfer any
+	// Error is the expected transport error, only set if the request has
+	// expect_error
+	Error any
+	// Streamed is set instead of Body for format "binary" with "stream"
+	Streamed *streamedBody
 
 	ReqDur      time.Duration
 	BodyLoadDur time.Duration
 }
 
+// responseRedirect is a followed redirect, the response with the Location
+type responseRedirect struct {
+	URL        string   `json:"url"`
+	StatusCode int      `json:"statuscode"`
+	Location   string   `json:"location"`
+	SetCookie  []string `json:"set_cookie"`
+}
+
+func newResponseRedirect(resp *http.Response) (redirect responseRedirect) {
+	redirect = responseRedirect{
+		StatusCode: resp.StatusCode,
+		Location:   resp.Header.Get("Location"),
+		SetCookie:  resp.Header.Values("Set-Cookie"),
+	}
+	if resp.Request != nil {
+		redirect.URL = resp.Request.URL.String()
+	}
+	if redirect.SetCookie == nil {
+		redirect.SetCookie = []string{}
+	}
+	return redirect
+}
+
 func httpHeaderToMap(header http.Header) (headers map[string]any, err error) {
 	headers = map[string]any{}
 	for k, h := range header {
@@ -44,9 +82,14 @@ type ResponseSerialization struct {
 	StatusCode  *int                   `yaml:"statuscode,omitempty" json:"statuscode,omitempty"`
 	Headers     map[string]any         `yaml:"header" json:"header,omitempty"`
 	Cookies     map[string]http.Cookie `yaml:"cookie" json:"cookie,omitempty"`
+	SetCookies  any                    `yaml:"set_cookies" json:"set_cookies,omitempty"`
+	Error       any                    `yaml:"error" json:"error,omitempty"`
 	Body        any                    `yaml:"body" json:"body,omitempty"`
 	BodyControl jsutil.Object          `yaml:"body:control" json:"body:control,omitempty"`
 	Format      ResponseFormat         `yaml:"format" json:"format"`
+	Encoding    any                    `yaml:"encoding" json:"encoding,omitempty"`
+	Redirects   any                    `yaml:"redirects" json:"redirects,omitempty"`
+	Transfer    any                    `yaml:"transfer" json:"transfer,omitempty"`
 }
 
 type responseSerializationInternal struct {
@@ -59,26 +102,67 @@ type responseFormatCSV struct {
 }
 
 type responseFormatXLSX struct {
-	SheetIdx int `json:"sheet_idx,omitempty"`
+	SheetIdx  int  `json:"sheet_idx,omitempty"`
+	AllSheets bool `json:"all_sheets,omitempty"` // convert all sheets, the body is an object with the sheet names as keys
+	Typed     bool `json:"typed,omitempty"`      // numbers and booleans are json numbers and booleans, empty cells are null
+	Cells     bool `json:"cells,omitempty"`      // cells are objects with value, type, text, formula, number format, font style and merged range
+}
+
+type responseFormatBinary struct {
+	Stream   bool `json:"stream,omitempty"`    // hash the body while reading it, without keeping it in memory
+	KeepFile bool `json:"keep_file,omitempty"` // with stream, write the body to a temporary file
+}
+
+type responseFormatSSE struct {
+	MaxEvents  int    `json:"max_events,omitempty"`  // stop reading after n events
+	UntilEvent string `json:"until_event,omitempty"` // stop reading after the first event of this type
+	UntilData  string `json:"until_data,omitempty"`  // stop reading after the first event with data matching this regex
+	TimeoutMS  int    `json:"timeout_ms,omitempty"`  // stop reading after n milliseconds, the events received so far are used
+	// stop reading if no data was received for n milliseconds, default
+	// sseDefaultIdleTimeoutMS if no other condition is set
+	IdleTimeoutMS int `json:"idle_timeout_ms,omitempty"`
 }
 
 const (
-	responseTypeXml    string = "xml"
-	responseTypeXml2   string = "xml2"
-	responseTypeHtml   string = "html"
-	responseTypeXhtml  string = "xhtml"
-	responseTypeXlsx   string = "xlsx"
-	responseTypeCsv    string = "csv"
-	responseTypeBinary string = "binary"
-	responseTypeText   string = "text"
+	responseTypeXml       string = "xml"
+	responseTypeXml2      string = "xml2"
+	responseTypeHtml      string = "html"
+	responseTypeXhtml     string = "xhtml"
+	responseTypeXlsx      string = "xlsx"
+	responseTypeCsv       string = "csv"
+	responseTypeBinary    string = "binary"
+	responseTypeText      string = "text"
+	responseTypeSSE       string = "sse"
+	responseTypeYaml      string = "yaml"
+	responseTypeToml      string = "toml"
+	responseTypeNdjson    string = "ndjson"
+	responseTypeMsgpack   string = "msgpack"
+	responseTypeCbor      string = "cbor"
+	responseTypeProtobuf  string = "protobuf"
+	responseTypeZip       string = "zip"
+	responseTypeTar       string = "tar"
+	responseTypePdf       string = "pdf"
+	responseTypeImage     string = "image"
+	responseTypeMultipart string = "multipart"
 )
 
 type ResponseFormat struct {
-	IgnoreBody bool               `json:"-"`    // if true, do not try to parse the body (since it is not expected in the response)
-	Type       string             `json:"type"` // default "json", allowed: "csv", "json", "xml", "xml2", "html", "xhtml", "binary", "text", "xlsx"
-	CSV        responseFormatCSV  `json:"csv"`  // ignored if type != "csv"
-	XLSX       responseFormatXLSX `json:"xlsx"` // ignored if type != "xlsx"
-	PreProcess *preProcess        `json:"pre_process,omitempty"`
+	IgnoreBody bool                    `json:"-"`                // if true, do not try to parse the body (since it is not expected in the response)
+	Type       string                  `json:"type"`             // default "json", allowed: "csv", "json", "xml", "xml2", "html", "xhtml", "binary", "text", "xlsx", "sse", "yaml", "toml", "ndjson", "msgpack", "cbor", "protobuf", "zip", "tar", "pdf", "image", "multipart"
+	CSV        responseFormatCSV       `json:"csv"`              // ignored if type != "csv"
+	XLSX       responseFormatXLSX      `json:"xlsx"`             // ignored if type != "xlsx"
+	SSE        responseFormatSSE       `json:"sse"`              // ignored if type != "sse"
+	Binary     responseFormatBinary    `json:"binary"`           // ignored if type != "binary"
+	Protobuf   protobufMessage         `json:"protobuf"`         // ignored if type != "protobuf"
+	Archive    responseFormatArchive   `json:"archive"`          // ignored if type != "zip" and type != "tar"
+	Image      responseFormatImage     `json:"image"`            // ignored if type != "image"
+	Multipart  responseFormatMultipart `json:"multipart"`        // ignored if type != "multipart"
+	Select     map[string]string       `json:"select,omitempty"` // for "xml", "xml2", "xhtml", "html": name to xpath or css selector, the values are the body
+	Decompress bool                    `json:"decompress"`       // decode the body according to the Content-Encoding header
+	PreProcess *preProcess             `json:"pre_process,omitempty"`
+	// ManifestDir is set programmatically, paths in the format (like the
+	// protoset) are relative to it
+	ManifestDir string `json:"-"`
 }
 
 func NewResponse(statusCode *int,
@@ -162,7 +246,16 @@ func NewResponseFromSpec(spec ResponseSerialization) (res Response, err error) {
 		}
 	}
 
-	return NewResponse(spec.StatusCode, spec.Headers, cookies, body, spec.BodyControl, spec.Format)
+	res, err = NewResponse(spec.StatusCode, spec.Headers, cookies, body, spec.BodyControl, spec.Format)
+	if err != nil {
+		return res, err
+	}
+	res.Encoding = spec.Encoding
+	res.Redirects = spec.Redirects
+	res.Transfer = spec.Transfer
+	res.SetCookies = spec.SetCookies
+	res.Error = spec.Error
+	return res, nil
 }
 
 // splitLines is a helper function needed for format "text"
@@ -217,8 +310,23 @@ func (response Response) ServerResponseToGenericJSON(responseFormat ResponseForm
 		if err != nil {
 			return res, fmt.Errorf("could not marshal xhtml to json: %w", err)
 		}
+	case responseTypeYaml:
+		bodyData, err = util.Yaml2Json(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal yaml to json: %w", err)
+		}
+	case responseTypeToml:
+		bodyData, err = util.Toml2Json(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal toml to json: %w", err)
+		}
 	case responseTypeXlsx:
-		bodyData, err = util.Xlsx2Json(resp.Body, responseFormat.XLSX.SheetIdx)
+		bodyData, err = util.Xlsx2Json(resp.Body, util.XlsxOptions{
+			SheetIdx:  responseFormat.XLSX.SheetIdx,
+			AllSheets: responseFormat.XLSX.AllSheets,
+			Typed:     responseFormat.XLSX.Typed,
+			Cells:     responseFormat.XLSX.Cells,
+		})
 		if err != nil {
 			return res, fmt.Errorf("could not marshal xlsx to json: %w", err)
 		}
@@ -238,15 +346,17 @@ func (response Response) ServerResponseToGenericJSON(responseFormat ResponseForm
 			return res, fmt.Errorf("could not marshal csv to json: %w", err)
 		}
 	case responseTypeBinary:
-		// We have another file format (binary). We thereby take the md5 Hash of the body and compare that one
-		hasher := md5.New()
-		hasher.Write([]byte(resp.Body))
-		jsonObject := jsutil.Object{
-			"md5sum": jsutil.String(hex.EncodeToString(hasher.Sum(nil))),
+		streamed := resp.Streamed
+		if streamed == nil {
+			// the body was read completely, hash it now
+			streamed, err = streamBody(bytes.NewReader(resp.Body), false)
+			if err != nil {
+				return res, fmt.Errorf("could not hash binary body: %w", err)
+			}
 		}
-		bodyData, err = jsutil.Marshal(jsonObject)
+		bodyData, err = jsutil.Marshal(streamed)
 		if err != nil {
-			return res, fmt.Errorf("could not marshal body with md5sum to json: %w", err)
+			return res, fmt.Errorf("could not marshal binary body to json: %w", err)
 		}
 	case responseTypeText:
 		// render the content as text
@@ -271,6 +381,56 @@ func (response Response) ServerResponseToGenericJSON(responseFormat ResponseForm
 		if err != nil {
 			return res, fmt.Errorf("could not marshal body to text (string): %w", err)
 		}
+	case responseTypeSSE:
+		bodyData, err = sse2Json(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal sse events to json: %w", err)
+		}
+	case responseTypeNdjson:
+		bodyData, err = ndjson2Json(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal ndjson to json: %w", err)
+		}
+	case responseTypeMsgpack:
+		bodyData, err = util.Msgpack2Json(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal msgpack to json: %w", err)
+		}
+	case responseTypeCbor:
+		bodyData, err = util.Cbor2Json(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal cbor to json: %w", err)
+		}
+	case responseTypeProtobuf:
+		bodyData, err = protobuf2Json(resp.Body, responseFormat.Protobuf, responseFormat.ManifestDir)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal protobuf to json: %w", err)
+		}
+	case responseTypeZip:
+		bodyData, err = zip2Json(resp.Body, responseFormat.Archive, responseFormat.ManifestDir)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal zip to json: %w", err)
+		}
+	case responseTypeTar:
+		bodyData, err = tar2Json(resp.Body, responseFormat.Archive, responseFormat.ManifestDir)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal tar to json: %w", err)
+		}
+	case responseTypePdf:
+		bodyData, err = util.Pdf2Json(resp.Body)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal pdf to json: %w", err)
+		}
+	case responseTypeImage:
+		bodyData, err = image2Json(resp.Body, responseFormat.Image, responseFormat.ManifestDir)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal image to json: %w", err)
+		}
+	case responseTypeMultipart:
+		bodyData, err = multipart2Json(resp.Body, resp.Headers, responseFormat.Multipart, responseFormat.ManifestDir)
+		if err != nil {
+			return res, fmt.Errorf("could not marshal multipart to json: %w", err)
+		}
 	case "":
 		// no specific format, we assume a json, and thereby try to unmarshal it into our body
 		bodyData = resp.Body
@@ -278,6 +438,14 @@ func (response Response) ServerResponseToGenericJSON(responseFormat ResponseForm
 		return res, fmt.Errorf("Invalid response format '%s'", responseFormat.Type)
 	}
 
+	// the selected values replace the converted document
+	if len(responseFormat.Select) > 0 {
+		bodyData, err = util.Select2Json(resp.Body, responseFormat.Type, responseFormat.Select)
+		if err != nil {
+			return res, fmt.Errorf("could not select values from %s: %w", responseFormat.Type, err)
+		}
+	}
+
 	headerFlat = map[string]any{}
 	headersAny = map[string]any{}
 	for key, value := range resp.Headers {
@@ -290,6 +458,10 @@ func (response Response) ServerResponseToGenericJSON(responseFormat ResponseForm
 		ResponseSerialization: ResponseSerialization{
 			StatusCode: resp.StatusCode,
 			Headers:    headersAny,
+			Encoding:   resp.Encoding,
+			Redirects:  resp.Redirects,
+			Transfer:   resp.Transfer,
+			Error:      resp.Error,
 		},
 		HeaderFlat: headerFlat,
 	}
@@ -304,6 +476,9 @@ func (response Response) ServerResponseToGenericJSON(responseFormat ResponseForm
 			responseJSON.Cookies[ck.Name] = *ck
 		}
 	}
+	if len(resp.Cookies) > 0 {
+		responseJSON.SetCookies = newResponseCookies(resp.Cookies)
+	}
 
 	// if the body should not be ignored, serialize the parsed/converted body
 	hasBody := false
@@ -370,6 +545,11 @@ func (response Response) ToGenericJSON() (res any, err error) {
 			StatusCode:  response.StatusCode,
 			Headers:     response.Headers,
 			BodyControl: response.BodyControl,
+			Encoding:    response.Encoding,
+			Redirects:   response.Redirects,
+			Transfer:    response.Transfer,
+			SetCookies:  response.SetCookies,
+			Error:       response.Error,
 		},
 		HeaderFlat: response.HeaderFlat,
 	}
@@ -455,7 +635,19 @@ func (response Response) ToString() (s string) {
 		responseTypeXlsx,
 		responseTypeCsv,
 		responseTypeBinary,
-		responseTypeText:
+		responseTypeText,
+		responseTypeSSE,
+		responseTypeYaml,
+		responseTypeToml,
+		responseTypeNdjson,
+		responseTypeMsgpack,
+		responseTypeCbor,
+		responseTypeProtobuf,
+		responseTypeZip,
+		responseTypeTar,
+		responseTypePdf,
+		responseTypeImage,
+		responseTypeMultipart:
 		bodyString, err = resp.ServerResponseToJsonString(true)
 		if err != nil {
 			bodyString = "[BINARY DATA NOT DISPLAYED]\n\n"
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+)
+
+// sseDefaultIdleTimeoutMS ends reading an event stream without stop
+// condition, so it does not block until the timeout of the client
+const sseDefaultIdleTimeoutMS = 1000
+
+// sseEvent is a single dispatched event of a text/event-stream
+type sseEvent struct {
+	Event string `json:"event"`
+	ID    string `json:"id"`
+	Data  string `json:"data"`
+	Retry *int   `json:"retry"`
+}
+
+// sseParser implements the line based event stream interpretation
+// from https://html.spec.whatwg.org/multipage/server-sent-events.html
+type sseParser struct {
+	event   string
+	data    []string
+	hasData bool
+	lastID  string
+	retry   *int
+}
+
+// line feeds a single line (without line ending) into the parser and returns
+// the event, if the line dispatched one
+func (p *sseParser) line(l string) (ev *sseEvent) {
+	if l == "" {
+		defer func() {
+			p.event = ""
+			p.data = nil
+			p.hasData = false
+			p.retry = nil
+		}()
+		if !p.hasData {
+			return nil
+		}
+		ev = &sseEvent{
+			Event: p.event,
+			ID:    p.lastID,
+			Data:  strings.Join(p.data, "\n"),
+			Retry: p.retry,
+		}
+		if ev.Event == "" {
+			ev.Event = "message"
+		}
+		return ev
+	}
+
+	// comment
+	if l[0] == ':' {
+		return nil
+	}
+
+	field, value, _ := strings.Cut(l, ":")
+	value = strings.TrimPrefix(value, " ")
+
+	switch field {
+	case "event":
+		p.event = value
+	case "data":
+		p.data = append(p.data, value)
+		p.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			p.lastID = value
+		}
+	case "retry":
+		n, err := strconv.Atoi(value)
+		if err == nil {
+			p.retry = &n
+		}
+	}
+	return nil
+}
+
+// sseLineReader reads the lines of an event stream. A line ends with "\r\n",
+// "\n" or "\r". The "\n" after a "\r" is skipped with the next line, so a
+// line is returned without waiting for more data. The UTF-8 BOM at the start
+// of the stream is stripped.
+type sseLineReader struct {
+	rd      *bufio.Reader
+	afterCR bool
+	started bool
+}
+
+func newSSELineReader(r io.Reader) (lr *sseLineReader) {
+	return &sseLineReader{rd: bufio.NewReader(r)}
+}
+
+// readLine reads a single line and strips the line ending. The returned raw
+// line includes the line ending.
+func (lr *sseLineReader) readLine() (l, raw string, err error) {
+	var line, rawLine strings.Builder
+	for {
+		c, err := lr.rd.ReadByte()
+		if err != nil {
+			return line.String(), rawLine.String(), err
+		}
+		rawLine.WriteByte(c)
+		afterCR := lr.afterCR
+		lr.afterCR = false
+		switch {
+		case c == '\n' && afterCR:
+			// second half of "\r\n"
+			continue
+		case c == '\r':
+			lr.afterCR = true
+		case c != '\n':
+			line.WriteByte(c)
+			continue
+		}
+
+		l = line.String()
+		if !lr.started {
+			lr.started = true
+			l = strings.TrimPrefix(l, "\uFEFF")
+		}
+		return l, rawLine.String(), nil
+	}
+}
+
+// readSSE reads the event stream from body until one of the stop conditions
+// in format is met or the stream ends. It returns the raw stream up to (and
+// including) the event which met the condition, so that parsing the returned
+// data yields exactly the events which were read.
+func readSSE(body io.ReadCloser, format responseFormatSSE) (raw []byte, err error) {
+	var (
+		untilData *regexp.Regexp
+		timedOut  atomic.Bool
+		parser    sseParser
+		sb        strings.Builder
+		count     int
+	)
+
+	if format.UntilData != "" {
+		untilData, err = regexp.Compile(format.UntilData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sse until_data regex %q: %w", format.UntilData, err)
+		}
+	}
+
+	if format.TimeoutMS > 0 {
+		// closing the body unblocks the pending read
+		timer := time.AfterFunc(time.Duration(format.TimeoutMS)*time.Millisecond, func() {
+			timedOut.Store(true)
+			body.Close()
+		})
+		defer timer.Stop()
+	}
+
+	idleTimeoutMS := format.IdleTimeoutMS
+	if idleTimeoutMS == 0 && format.MaxEvents == 0 && format.UntilEvent == "" && format.UntilData == "" && format.TimeoutMS == 0 {
+		idleTimeoutMS = sseDefaultIdleTimeoutMS
+	}
+	var idleTimer *time.Timer
+	if idleTimeoutMS > 0 {
+		idleTimer = time.AfterFunc(time.Duration(idleTimeoutMS)*time.Millisecond, func() {
+			timedOut.Store(true)
+			body.Close()
+		})
+		defer idleTimer.Stop()
+	}
+
+	lr := newSSELineReader(body)
+	for {
+		l, rawLine, err := lr.readLine()
+		if err != nil {
+			if timedOut.Load() || errors.Is(err, io.EOF) {
+				// an incomplete trailing line is kept, parsing
+				// ignores the unfinished event
+				sb.WriteString(rawLine)
+				return []byte(sb.String()), nil
+			}
+			return nil, fmt.Errorf("reading sse stream: %w", err)
+		}
+		sb.WriteString(rawLine)
+		if idleTimer != nil {
+			idleTimer.Reset(time.Duration(idleTimeoutMS) * time.Millisecond)
+		}
+
+		ev := parser.line(l)
+		if ev == nil {
+			continue
+		}
+		count++
+		if format.MaxEvents > 0 && count >= format.MaxEvents {
+			break
+		}
+		if format.UntilEvent != "" && ev.Event == format.UntilEvent {
+			break
+		}
+		if untilData != nil && untilData.MatchString(ev.Data) {
+			break
+		}
+	}
+
+	return []byte(sb.String()), nil
+}
+
+// sse2Json parses the raw event stream and converts it into a json array
+// of events
+func sse2Json(raw []byte) (jsonStr []byte, err error) {
+	var (
+		parser sseParser
+		events []sseEvent
+	)
+
+	events = []sseEvent{}
+	lr := newSSELineReader(strings.NewReader(string(raw)))
+	for {
+		l, _, err := lr.readLine()
+		if err != nil {
+			// an unterminated last line can not complete an event
+			break
+		}
+		ev := parser.line(l)
+		if ev != nil {
+			events = append(events, *ev)
+		}
+	}
+
+	return jsutil.Marshal(events)
+}
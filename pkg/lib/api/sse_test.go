@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/tidwall/gjson"
+)
+
+// sseTestServer sends the given events and keeps the stream open until the
+// client goes away
+func sseTestServer(events string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, events)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+func TestSSE_Parse(t *testing.T) {
+	raw := ": comment\n" +
+		"data: first\n\n" +
+		"event: update\r\n" +
+		"id: 7\r\n" +
+		"retry: 1500\r\n" +
+		"data: line1\r\n" +
+		"data:line2\r\n\r\n" +
+		"event: no_data\n\n" +
+		"data: unterminated\n"
+
+	jsonBytes, err := sse2Json([]byte(raw))
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+	var events []sseEvent
+	err = jsutil.Unmarshal(jsonBytes, &events)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, len(events), 2)
+
+	go_test_utils.AssertStringEquals(t, events[0].Event, "message")
+	go_test_utils.AssertStringEquals(t, events[0].Data, "first")
+	if events[0].Retry != nil {
+		t.Errorf("expected no retry for first event, got %d", *events[0].Retry)
+	}
+
+	go_test_utils.AssertStringEquals(t, events[1].Event, "update")
+	go_test_utils.AssertStringEquals(t, events[1].ID, "7")
+	go_test_utils.AssertStringEquals(t, events[1].Data, "line1\nline2")
+	go_test_utils.AssertIntEquals(t, *events[1].Retry, 1500)
+}
+
+func TestSSE_LineEndings(t *testing.T) {
+	for name, raw := range map[string]string{
+		"lf":    "\uFEFFevent: a\ndata: 1\n\ndata: 2\n\n",
+		"cr":    "\uFEFFevent: a\rdata: 1\r\rdata: 2\r\r",
+		"crlf":  "\uFEFFevent: a\r\ndata: 1\r\n\r\ndata: 2\r\n\r\n",
+		"mixed": "event: a\r\ndata: 1\r\rdata: 2\n\r",
+	} {
+		t.Run(name, func(t *testing.T) {
+			jsonBytes, err := sse2Json([]byte(raw))
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertStringEquals(t, string(jsonBytes),
+				`[{"event":"a","id":"","data":"1","retry":null},{"event":"message","id":"","data":"2","retry":null}]`)
+		})
+	}
+}
+
+func TestSSE_SendStopConditions(t *testing.T) {
+	// all line endings are used, a "\r" must not wait for a following "\n"
+	ts := sseTestServer("\uFEFFdata: 1\r\revent: done\r\ndata: 2\r\n\r\ndata: 3\n\n")
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name   string
+		format responseFormatSSE
+		count  int
+	}{
+		{"max_events", responseFormatSSE{MaxEvents: 1}, 1},
+		{"until_event", responseFormatSSE{UntilEvent: "done"}, 2},
+		{"until_data", responseFormatSSE{UntilData: "^3$"}, 3},
+		{"timeout", responseFormatSSE{TimeoutMS: 200}, 3},
+		{"idle_timeout", responseFormatSSE{IdleTimeoutMS: 200}, 3},
+		{"default idle timeout", responseFormatSSE{}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := Request{
+				ServerURL: ts.URL,
+				Method:    "GET",
+				ResponseFormat: ResponseFormat{
+					Type: responseTypeSSE,
+					SSE:  tc.format,
+				},
+			}
+
+			done := make(chan struct{})
+			var (
+				response Response
+				err      error
+			)
+			go func() {
+				response, err = request.Send()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("reading the event stream did not stop")
+			}
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+			response.Format = request.ResponseFormat
+			jsonStr, err := response.ServerResponseToJsonString(true)
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "#").Int()), tc.count)
+		})
+	}
+}
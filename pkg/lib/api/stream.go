@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/programmfabrik/apitest/pkg/lib/filesystem"
+	"github.com/spf13/afero"
+)
+
+// streamedBody is the body of a response with format "binary". With
+// "stream" it replaces the body, the body itself is never held in memory.
+type streamedBody struct {
+	MD5Sum   string `json:"md5sum"`
+	SHA1     string `json:"sha1"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`      // detected from the magic bytes at the start of the body
+	File     string `json:"file,omitempty"` // path of the temporary file with the body, if "keep_file" is set
+}
+
+// mimeDetectLimit is the number of bytes at the start of the body which are
+// used to detect the mime type, the default of mimetype
+const mimeDetectLimit = 3072
+
+// headWriter keeps the first limit bytes written to it
+type headWriter struct {
+	head  []byte
+	limit int
+}
+
+func (hw *headWriter) Write(p []byte) (n int, err error) {
+	if rest := hw.limit - len(hw.head); rest > 0 {
+		hw.head = append(hw.head, p[:min(rest, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// keptFiles are the temporary files of "keep_file", they can be used by the
+// following tests and are removed by RemoveKeptFiles
+var keptFiles struct {
+	sync.Mutex
+	paths []string
+}
+
+// RemoveKeptFiles removes the temporary files of streamed bodies. It is
+// called at the end of a suite.
+func RemoveKeptFiles() {
+	keptFiles.Lock()
+	defer keptFiles.Unlock()
+	for _, path := range keptFiles.paths {
+		_ = filesystem.Fs.Remove(path)
+	}
+	keptFiles.paths = nil
+}
+
+// streamBody reads the body, hashing and counting it on the way. With
+// keepFile, the body is also written to a temporary file which is removed
+// by RemoveKeptFiles.
+func streamBody(body io.Reader, keepFile bool) (streamed *streamedBody, err error) {
+	md5Hash := md5.New()
+	sha1Hash := sha1.New()
+	sha256Hash := sha256.New()
+	head := &headWriter{limit: mimeDetectLimit}
+	w := io.MultiWriter(md5Hash, sha1Hash, sha256Hash, head)
+
+	streamed = &streamedBody{}
+	if keepFile {
+		f, err := afero.TempFile(filesystem.Fs, "", "apitest-body-*")
+		if err != nil {
+			return nil, fmt.Errorf("creating file for body: %w", err)
+		}
+		defer f.Close()
+		streamed.File = f.Name()
+		w = io.MultiWriter(w, f)
+	}
+
+	streamed.Size, err = io.Copy(w, body)
+	if err != nil {
+		if streamed.File != "" {
+			_ = filesystem.Fs.Remove(streamed.File)
+		}
+		return nil, fmt.Errorf("streaming body: %w", err)
+	}
+	if streamed.File != "" {
+		keptFiles.Lock()
+		keptFiles.paths = append(keptFiles.paths, streamed.File)
+		keptFiles.Unlock()
+	}
+	streamed.MD5Sum = hex.EncodeToString(md5Hash.Sum(nil))
+	streamed.SHA1 = hex.EncodeToString(sha1Hash.Sum(nil))
+	streamed.SHA256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	streamed.MimeType = mimetype.Detect(head.head).String()
+	return streamed, nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/programmfabrik/apitest/pkg/lib/filesystem"
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/spf13/afero"
+	"github.com/tidwall/gjson"
+)
+
+func TestStream_GeneratedBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprintf(w, `{"content_length":%d,"read":%d,"chunked":%t}`, r.ContentLength, n, len(r.TransferEncoding) > 0)
+	}))
+	defer ts.Close()
+
+	request := Request{
+		ServerURL: ts.URL,
+		Method:    "PUT",
+		BodyType:  "generate",
+		BodyGenerate: requestBodyGenerate{
+			Size:    10 << 20,
+			Pattern: "abc",
+		},
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, string(response.Body), `{"content_length":10485760,"read":10485760,"chunked":false}`)
+
+	g := &generatedBody{pattern: []byte("abc"), size: 7}
+	data, err := io.ReadAll(g)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, string(data), "abcabca")
+
+	exp := `yes 'abc' | tr -d '\n' | head -c 10485760 | curl -X 'PUT' --data-binary @- -H 'Content-Type: application/octet-stream' -H 'User-Agent: ' '` + ts.URL + `'`
+	go_test_utils.AssertStringEquals(t, request.ToString(true), exp)
+}
+
+func TestStream_BinaryResponse(t *testing.T) {
+	size := 3 << 20
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		io.Copy(w, &generatedBody{pattern: []byte("xyz"), size: int64(size)})
+	}))
+	defer ts.Close()
+
+	expected := []byte(strings.Repeat("xyz", size/3))
+	md5Sum := md5.Sum(expected)
+	sha256Sum := sha256.Sum256(expected)
+
+	filesystem.Fs = afero.NewMemMapFs()
+	request := Request{
+		ServerURL: ts.URL,
+		Method:    "GET",
+		ResponseFormat: ResponseFormat{
+			Type: responseTypeBinary,
+			Binary: responseFormatBinary{
+				Stream:   true,
+				KeepFile: true,
+			},
+		},
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, len(response.Body), 0)
+
+	response.Format = request.ResponseFormat
+	jsonStr, err := response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "md5sum").String(), hex.EncodeToString(md5Sum[:]))
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "sha256").String(), hex.EncodeToString(sha256Sum[:]))
+	go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "size").Int()), size)
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "mime_type").String(), "text/plain; charset=utf-8")
+
+	kept, err := afero.ReadFile(filesystem.Fs, gjson.Get(jsonStr, "file").String())
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, len(kept), size)
+
+	// the file is removed at the end of the suite
+	RemoveKeptFiles()
+	_, err = filesystem.Fs.Stat(gjson.Get(jsonStr, "file").String())
+	go_test_utils.ExpectError(t, err, "kept file was not removed")
+}
+
+func TestStream_ExpectError(t *testing.T) {
+	// the connection is closed in the middle of the body
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+
+	filesystem.Fs = afero.NewMemMapFs()
+	request := Request{
+		ServerURL:   ts.URL,
+		Method:      "GET",
+		ExpectError: transportErrorReset,
+		ResponseFormat: ResponseFormat{
+			Type: responseTypeBinary,
+			Binary: responseFormatBinary{
+				Stream:   true,
+				KeepFile: true,
+			},
+		},
+	}
+	response, err := request.Send()
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, response.Error.(responseError).Kind, transportErrorReset)
+
+	// the file of the incomplete body is removed
+	files, err := afero.ReadDir(filesystem.Fs, afero.GetTempDir(filesystem.Fs, ""))
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertIntEquals(t, len(files), 0)
+}
+
+func TestStream_BinaryNotStreamed(t *testing.T) {
+	// the 8 byte PNG signature and the start of the IHDR chunk
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	sha1Sum := sha1.Sum(png)
+
+	response := Response{
+		StatusCode: new(http.StatusOK),
+		Body:       png,
+		Format:     ResponseFormat{Type: responseTypeBinary},
+	}
+	jsonStr, err := response.ServerResponseToJsonString(true)
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "sha1").String(), hex.EncodeToString(sha1Sum[:]))
+	go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "size").Int()), len(png))
+	go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "mime_type").String(), "image/png")
+	if gjson.Get(jsonStr, "file").Exists() {
+		t.Errorf("expected no file for a body which is not streamed, got %s", jsonStr)
+	}
+}
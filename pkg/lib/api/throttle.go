@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// errUploadAborted is returned by the throttled body after abort_after_bytes
+var errUploadAborted = errors.New("upload aborted by abort_after_bytes")
+
+// responseTransfer is added to the response as "transfer" if the request
+// body is throttled, paused or aborted
+type responseTransfer struct {
+	SentBytes int64  `json:"sent_bytes"`
+	Aborted   bool   `json:"aborted"`
+	Error     string `json:"error,omitempty"`
+}
+
+// throttledBody wraps the request body to simulate slow or stalled clients
+type throttledBody struct {
+	r          io.ReadCloser
+	rate       int64  // bytes per second, 0 is unlimited
+	pauseAfter *int64 // pause once after this many bytes
+	pause      time.Duration
+	abortAfter *int64 // fail the read after this many bytes
+
+	start   time.Time
+	paused  bool
+	sent    atomic.Int64
+	aborted atomic.Bool
+}
+
+func (request Request) throttled() (throttled bool) {
+	return request.RateLimitBytesPerSec > 0 || request.PauseAfterBytes != nil || request.AbortAfterBytes != nil
+}
+
+func newThrottledBody(request Request, body io.ReadCloser) (tb *throttledBody) {
+	return &throttledBody{
+		r:          body,
+		rate:       request.RateLimitBytesPerSec,
+		pauseAfter: request.PauseAfterBytes,
+		pause:      time.Duration(request.PauseMS) * time.Millisecond,
+		abortAfter: request.AbortAfterBytes,
+	}
+}
+
+func (tb *throttledBody) Read(p []byte) (n int, err error) {
+	if tb.start.IsZero() {
+		tb.start = time.Now()
+	}
+	sent := tb.sent.Load()
+
+	if tb.abortAfter != nil {
+		if sent >= *tb.abortAfter {
+			tb.aborted.Store(true)
+			return 0, errUploadAborted
+		}
+		p = limitBuf(p, *tb.abortAfter-sent)
+	}
+	if tb.pauseAfter != nil && !tb.paused {
+		if sent >= *tb.pauseAfter {
+			tb.paused = true
+			time.Sleep(tb.pause)
+		} else {
+			p = limitBuf(p, *tb.pauseAfter-sent)
+		}
+	}
+	if tb.rate > 0 {
+		// send in slices of 1/10 second
+		p = limitBuf(p, max(tb.rate/10, 1))
+	}
+
+	n, err = tb.r.Read(p)
+	sent = tb.sent.Add(int64(n))
+
+	if tb.rate > 0 {
+		// return the bytes not before they are due at the rate
+		due := tb.start.Add(time.Duration(float64(sent) / float64(tb.rate) * float64(time.Second)))
+		time.Sleep(time.Until(due))
+	}
+	return n, err
+}
+
+func (tb *throttledBody) Close() (err error) {
+	return tb.r.Close()
+}
+
+func (tb *throttledBody) transfer(err error) (transfer responseTransfer) {
+	transfer = responseTransfer{
+		SentBytes: tb.sent.Load(),
+		Aborted:   tb.aborted.Load(),
+	}
+	if err != nil {
+		transfer.Error = err.Error()
+	}
+	return transfer
+}
+
+func limitBuf(p []byte, n int64) (limited []byte) {
+	if int64(len(p)) > n {
+		return p[:n]
+	}
+	return p
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/tidwall/gjson"
+)
+
+func TestThrottle(t *testing.T) {
+	received := make(chan int64, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		received <- n
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, `{"read":%d}`, n)
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name       string
+		rate       int64
+		pauseAfter *int64
+		pauseMS    int
+		abortAfter *int64
+		minDur     time.Duration
+		status     int
+		sent       int64
+	}{
+		{name: "rate limit", rate: 100_000, minDur: 150 * time.Millisecond, status: 200, sent: 20_000},
+		{name: "pause", pauseAfter: new(int64(10)), pauseMS: 200, minDur: 200 * time.Millisecond, status: 200, sent: 20_000},
+		{name: "abort", abortAfter: new(int64(1000)), status: 0, sent: 1000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := Request{
+				ServerURL: ts.URL,
+				Method:    "POST",
+				BodyType:  "generate",
+				BodyGenerate: requestBodyGenerate{
+					Size: 20_000,
+				},
+				RateLimitBytesPerSec: tc.rate,
+				PauseAfterBytes:      tc.pauseAfter,
+				PauseMS:              tc.pauseMS,
+				AbortAfterBytes:      tc.abortAfter,
+			}
+			start := time.Now()
+			response, err := request.Send()
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			if time.Since(start) < tc.minDur {
+				t.Errorf("expected request to take at least %s, took %s", tc.minDur, time.Since(start))
+			}
+			go_test_utils.AssertIntEquals(t, *response.StatusCode, tc.status)
+
+			jsonStr, err := response.ServerResponseToJsonString(false)
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertIntEquals(t, int(gjson.Get(jsonStr, "transfer.sent_bytes").Int()), int(tc.sent))
+			go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "transfer.aborted").String(), fmt.Sprint(tc.abortAfter != nil))
+
+			// the server only got the bytes sent before the abort
+			select {
+			case n := <-received:
+				go_test_utils.AssertIntEquals(t, int(n), int(tc.sent))
+			case <-time.After(5 * time.Second):
+				t.Fatal("server did not finish reading the body")
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kinds of transport errors for expect_error
+const (
+	transportErrorConnectionRefused = "connection_refused"
+	transportErrorTimeout           = "timeout"
+	transportErrorTLSHandshake      = "tls_handshake"
+	transportErrorReset             = "reset"
+	transportErrorDNS               = "dns"
+	transportErrorOther             = "other"
+	// transportErrorAny in expect_error accepts every kind
+	transportErrorAny = "any"
+)
+
+var transportErrorKinds = map[string]bool{
+	transportErrorConnectionRefused: true,
+	transportErrorTimeout:           true,
+	transportErrorTLSHandshake:      true,
+	transportErrorReset:             true,
+	transportErrorDNS:               true,
+	transportErrorOther:             true,
+	transportErrorAny:               true,
+}
+
+// responseError is added to the response as "error" if the request failed
+// with the expected transport error
+type responseError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// transportErrorKind classifies an error of the http client
+func transportErrorKind(err error) (kind string) {
+	var (
+		netErr  net.Error
+		dnsErr  *net.DNSError
+		hdrErr  tls.RecordHeaderError
+		alert   tls.AlertError
+		certErr *tls.CertificateVerificationError
+		unkAuth x509.UnknownAuthorityError
+	)
+	// the grpc client only reports the status, not the error of the dial
+	if st, ok := status.FromError(err); ok {
+		return grpcTransportErrorKind(st)
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return transportErrorConnectionRefused
+	case errors.As(err, &dnsErr):
+		return transportErrorDNS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return transportErrorTimeout
+	case errors.As(err, &hdrErr), errors.As(err, &alert), errors.As(err, &certErr), errors.As(err, &unkAuth),
+		// the transport replaces the tls.RecordHeaderError of a plain http server
+		strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"),
+		strings.Contains(err.Error(), "tls: "):
+		return transportErrorTLSHandshake
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		// the transport reports a connection closed before the request was
+		// written with an unexported error
+		strings.Contains(err.Error(), "http: server closed idle connection"):
+		return transportErrorReset
+	}
+	return transportErrorOther
+}
+
+// grpcTransportErrorKind classifies the status of a failed grpc call by
+// its message
+func grpcTransportErrorKind(st *status.Status) (kind string) {
+	msg := st.Message()
+	switch {
+	case st.Code() == codes.DeadlineExceeded:
+		return transportErrorTimeout
+	case strings.Contains(msg, "connection refused"):
+		return transportErrorConnectionRefused
+	case strings.Contains(msg, "no such host"), strings.Contains(msg, "produced zero addresses"):
+		return transportErrorDNS
+	case strings.Contains(msg, "authentication handshake failed"), strings.Contains(msg, "tls: "):
+		return transportErrorTLSHandshake
+	case strings.Contains(msg, "connection reset"), strings.Contains(msg, "EOF"):
+		return transportErrorReset
+	}
+	return transportErrorOther
+}
+
+// checkExpectError validates expect_error of the request
+func (request Request) checkExpectError() (err error) {
+	if request.ExpectError == "" {
+		return nil
+	}
+	if !transportErrorKinds[request.ExpectError] {
+		return fmt.Errorf("expect_error %q is not supported", request.ExpectError)
+	}
+	if request.Jar != nil {
+		return fmt.Errorf("expect_error is not supported for cookie_jar requests, they are not sent")
+	}
+	return nil
+}
+
+// expectedErrorResponse returns the response for a transport error which
+// was expected, or the error itself
+func (request Request) expectedErrorResponse(err error) (response Response, errOut error) {
+	if request.ExpectError == "" {
+		return response, err
+	}
+	kind := transportErrorKind(err)
+	if request.ExpectError != transportErrorAny && request.ExpectError != kind {
+		return response, fmt.Errorf("expected error %q, got %q: %w", request.ExpectError, kind, err)
+	}
+	response, errOut = NewResponse(new(0), nil, nil, nil, nil, ResponseFormat{})
+	if errOut != nil {
+		return response, errOut
+	}
+	response.Error = responseError{
+		Kind:    kind,
+		Message: err.Error(),
+	}
+	return response, nil
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	go_test_utils "github.com/programmfabrik/go-test-utils"
+	"github.com/tidwall/gjson"
+)
+
+func TestTransportError_Kinds(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	// a port without a listener
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	refusedURL := "http://" + lis.Addr().String()
+	lis.Close()
+
+	// a server which closes every connection
+	resetLis, err := net.Listen("tcp", "127.0.0.1:0")
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	defer resetLis.Close()
+	go func() {
+		for {
+			conn, err := resetLis.Accept()
+			if err != nil {
+				return
+			}
+			// read the request, the connection is closed while the
+			// client waits for the response
+			http.ReadRequest(bufio.NewReader(conn))
+			conn.Close()
+		}
+	}()
+
+	for _, tc := range []struct {
+		serverURL string
+		timeoutMS int
+		kind      string
+	}{
+		{refusedURL, 0, transportErrorConnectionRefused},
+		{slow.URL, 100, transportErrorTimeout},
+		{strings.Replace(slow.URL, "http://", "https://", 1), 0, transportErrorTLSHandshake},
+		{"http://" + resetLis.Addr().String(), 0, transportErrorReset},
+	} {
+		t.Run(tc.kind, func(t *testing.T) {
+			request := Request{
+				ServerURL:   tc.serverURL,
+				Method:      "GET",
+				TimeoutMS:   tc.timeoutMS,
+				ExpectError: tc.kind,
+			}
+			response, err := request.Send()
+			if err != nil {
+				t.Fatal(err)
+			}
+			go_test_utils.AssertIntEquals(t, *response.StatusCode, 0)
+
+			jsonStr, err := response.ServerResponseToJsonString(false)
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "error.kind").String(), tc.kind)
+
+			request.ExpectError = transportErrorAny
+			_, err = request.Send()
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+
+			request.ExpectError = ""
+			_, err = request.Send()
+			go_test_utils.ExpectError(t, err, "Send did not fail without expect_error")
+		})
+	}
+
+	request := Request{
+		ServerURL:   refusedURL,
+		Method:      "GET",
+		ExpectError: transportErrorTimeout,
+	}
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail on another kind of error")
+
+	request = Request{
+		ServerURL:   slow.URL,
+		Method:      "GET",
+		ExpectError: transportErrorTimeout,
+	}
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail on a response with expect_error")
+
+	request.ExpectError = "unreachable"
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail on unsupported expect_error")
+}
+
+func TestTransportError_OtherRequests(t *testing.T) {
+	// a port without a listener
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	refusedAddr := lis.Addr().String()
+	lis.Close()
+
+	// a server which accepts connections and never answers
+	silentLis, err := net.Listen("tcp", "127.0.0.1:0")
+	go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+	defer silentLis.Close()
+	go func() {
+		for {
+			conn, err := silentLis.Accept()
+			if err != nil {
+				return
+			}
+			// the connections are closed with the listener
+			defer conn.Close()
+		}
+	}()
+
+	healthCheck := &grpcRequest{
+		Service: "grpc.health.v1.Health",
+		Method:  "Check",
+	}
+
+	for _, tc := range []struct {
+		name    string
+		request Request
+		kind    string
+	}{
+		{
+			"raw refused",
+			Request{ServerURL: refusedAddr, Raw: &rawRequest{Request: "GET / HTTP/1.1\r\n\r\n"}},
+			transportErrorConnectionRefused,
+		},
+		{
+			"raw timeout",
+			Request{ServerURL: silentLis.Addr().String(), Raw: &rawRequest{Request: "GET / HTTP/1.1\r\n\r\n", TimeoutMS: 100}},
+			transportErrorTimeout,
+		},
+		{
+			"grpc refused",
+			Request{ServerURL: "grpc://" + refusedAddr, GRPC: healthCheck},
+			transportErrorConnectionRefused,
+		},
+		{
+			"grpc timeout",
+			Request{ServerURL: "grpc://" + silentLis.Addr().String(), TimeoutMS: 100, GRPC: healthCheck},
+			transportErrorTimeout,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := tc.request
+			request.ExpectError = tc.kind
+			response, err := request.Send()
+			if err != nil {
+				t.Fatal(err)
+			}
+			go_test_utils.AssertIntEquals(t, *response.StatusCode, 0)
+
+			jsonStr, err := response.ServerResponseToJsonString(false)
+			go_test_utils.ExpectNoError(t, err, errorStringIfNotNil(err))
+			go_test_utils.AssertStringEquals(t, gjson.Get(jsonStr, "error.kind").String(), tc.kind)
+
+			request.ExpectError = ""
+			_, err = request.Send()
+			go_test_utils.ExpectError(t, err, "Send did not fail without expect_error")
+		})
+	}
+
+	request := Request{
+		ServerURL:   "http://localhost",
+		Jar:         &cookieJarRequest{},
+		ExpectError: transportErrorAny,
+	}
+	_, err = request.Send()
+	go_test_utils.ExpectError(t, err, "Send did not fail on expect_error for a cookie_jar request")
+}
+
+func TestTransportError_Curl(t *testing.T) {
+	request := Request{
+		ServerURL: "http://localhost",
+		Method:    "GET",
+		TimeoutMS: 1500,
+	}
+	if !strings.HasPrefix(request.ToString(true), "curl --max-time 1.5 -X 'GET'") {
+		t.Errorf("expected --max-time in curl command, got %s", request.ToString(true))
+	}
+}
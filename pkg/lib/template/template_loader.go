@@ -254,6 +254,42 @@ func (loader *Loader) Render(
 
 			return string(bytes), nil
 		},
+		"file_yaml2json": func(path string) (jsonBytes string, err error) {
+			var (
+				fileBytes []byte
+				bytes     []byte
+			)
+
+			fileBytes, err = fileReadInternal(path, rootDir)
+			if err != nil {
+				return "", err
+			}
+
+			bytes, err = util.Yaml2Json(fileBytes)
+			if err != nil {
+				return "", fmt.Errorf("could not marshal yaml to json: %w", err)
+			}
+
+			return string(bytes), nil
+		},
+		"file_toml2json": func(path string) (jsonBytes string, err error) {
+			var (
+				fileBytes []byte
+				bytes     []byte
+			)
+
+			fileBytes, err = fileReadInternal(path, rootDir)
+			if err != nil {
+				return "", err
+			}
+
+			bytes, err = util.Toml2Json(fileBytes)
+			if err != nil {
+				return "", fmt.Errorf("could not marshal toml to json: %w", err)
+			}
+
+			return string(bytes), nil
+		},
 		"file_path": func(path string) (file_path string) {
 			return util.LocalPath(path, rootDir)
 		},
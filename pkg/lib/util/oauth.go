@@ -216,3 +216,50 @@ func (c OAuthClientConfig) GetAuthToken(params ...string) (token *oauth2.Token,
 	}
 	return token, nil
 }
+
+// passwordTokenSource gets a token with the password grant. It uses the
+// refresh token of the last token first, if there is one.
+type passwordTokenSource struct {
+	cfg      oauth2.Config
+	ctx      context.Context
+	username string
+	password string
+	last     *oauth2.Token
+}
+
+func (ts *passwordTokenSource) Token() (token *oauth2.Token, err error) {
+	if ts.last != nil && ts.last.RefreshToken != "" {
+		token, err = ts.cfg.TokenSource(ts.ctx, ts.last).Token()
+		if err == nil {
+			ts.last = token
+			return token, nil
+		}
+	}
+	token, err = ts.cfg.PasswordCredentialsToken(ts.ctx, ts.username, ts.password)
+	if err != nil {
+		return nil, err
+	}
+	ts.last = token
+	return token, nil
+}
+
+// PasswordCredentialsTokenSource returns a token source for the password
+// grant, which returns the same token until it is expired
+func (c OAuthClientConfig) PasswordCredentialsTokenSource(username string, password string) (ts oauth2.TokenSource) {
+	httpClient := &http.Client{Timeout: 60 * time.Second}
+	return oauth2.ReuseTokenSource(nil, &passwordTokenSource{
+		cfg:      getOAuthClientConfig(c),
+		ctx:      context.WithValue(context.Background(), oauth2.HTTPClient, httpClient),
+		username: username,
+		password: password,
+	})
+}
+
+// ClientCredentialsTokenSource returns a token source for the client
+// credentials grant, which returns the same token until it is expired
+func (c OAuthClientConfig) ClientCredentialsTokenSource() (ts oauth2.TokenSource) {
+	httpClient := &http.Client{Timeout: 60 * time.Second}
+	cfg := getOAuthClientCredentialsConfig(c)
+	cfg.Scopes = c.Scopes
+	return cfg.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, httpClient))
+}
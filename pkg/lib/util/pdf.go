@@ -0,0 +1,125 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+)
+
+// pdfPage is the text of a page of a pdf document
+type pdfPage struct {
+	Number int      `json:"number"`
+	Text   string   `json:"text"`
+	Lines  []string `json:"lines"` // trimmed, without empty lines
+}
+
+// pdfDocument is the metadata of the info dictionary and the text of a pdf
+// document
+type pdfDocument struct {
+	Title        string    `json:"title"`
+	Author       string    `json:"author"`
+	Subject      string    `json:"subject"`
+	Keywords     string    `json:"keywords"`
+	Creator      string    `json:"creator"`
+	Producer     string    `json:"producer"`
+	CreationDate string    `json:"creation_date"` // RFC 3339 if it can be parsed
+	ModDate      string    `json:"mod_date"`      // RFC 3339 if it can be parsed
+	PageCount    int       `json:"page_count"`
+	Text         string    `json:"text"` // text of all pages
+	Pages        []pdfPage `json:"pages"`
+}
+
+// pdfDateLayouts are the possible forms of a pdf date after removing the
+// "D:" prefix and the apostrophes of the timezone: the fields after the year
+// are optional
+var pdfDateLayouts = []string{
+	"20060102150405Z0700",
+	"20060102150405Z07",
+	"20060102150405",
+	"200601021504",
+	"2006010215",
+	"20060102",
+	"200601",
+	"2006",
+}
+
+// pdfDate converts a pdf date like "D:20240501120000+02'00'" into RFC 3339,
+// other values are returned as they are
+func pdfDate(raw string) (date string) {
+	s := strings.ReplaceAll(strings.TrimPrefix(raw, "D:"), "'", "")
+	for _, layout := range pdfDateLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return raw
+}
+
+// Pdf2Json extracts the metadata and the text of the pages of the raw pdf
+// data and converts it into a json string
+func Pdf2Json(rawPdf []byte) (jsonStr []byte, err error) {
+	var (
+		doc pdfDocument
+	)
+
+	// the pdf reader panics on some broken documents
+	defer func() {
+		if r := recover(); r != nil {
+			jsonStr = []byte{}
+			err = fmt.Errorf("could not parse pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(rawPdf), int64(len(rawPdf)))
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not parse pdf: %w", err)
+	}
+
+	info := reader.Trailer().Key("Info")
+	doc = pdfDocument{
+		Title:        info.Key("Title").Text(),
+		Author:       info.Key("Author").Text(),
+		Subject:      info.Key("Subject").Text(),
+		Keywords:     info.Key("Keywords").Text(),
+		Creator:      info.Key("Creator").Text(),
+		Producer:     info.Key("Producer").Text(),
+		CreationDate: pdfDate(info.Key("CreationDate").Text()),
+		ModDate:      pdfDate(info.Key("ModDate").Text()),
+		PageCount:    reader.NumPage(),
+		Pages:        []pdfPage{},
+	}
+
+	texts := []string{}
+	for i := 1; i <= doc.PageCount; i++ {
+		text, err := reader.Page(i).GetPlainText(nil)
+		if err != nil {
+			return []byte{}, fmt.Errorf("could not read text of pdf page %d: %w", i, err)
+		}
+		page := pdfPage{
+			Number: i,
+			Text:   strings.TrimSpace(text),
+			Lines:  []string{},
+		}
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" {
+				page.Lines = append(page.Lines, line)
+			}
+		}
+		doc.Pages = append(doc.Pages, page)
+		texts = append(texts, page.Text)
+	}
+	doc.Text = strings.Join(texts, "\n")
+
+	jsonStr, err = jsutil.Marshal(doc)
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not convert to json: %w", err)
+	}
+
+	return jsonStr, nil
+}
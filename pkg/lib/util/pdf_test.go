@@ -0,0 +1,101 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// testPdf builds a minimal pdf document with one text line per entry of the
+// pages, using the Helvetica standard font
+func testPdf(info string, pages ...[]string) []byte {
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // pages, set below
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		info,
+	}
+	kids := []string{}
+	for i, lines := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", len(objs)+1))
+		content := "BT /F1 12 Tf 72 720 Td 14 TL\n"
+		for _, line := range lines {
+			content += fmt.Sprintf("(%s) Tj T*\n", line)
+		}
+		content += "ET"
+		objs = append(objs,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objs[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	buf := bytes.NewBufferString("%PDF-1.4\n")
+	offsets := []int{}
+	for i, obj := range objs {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	return buf.Bytes()
+}
+
+func TestPdf2Json(t *testing.T) {
+	raw := testPdf(
+		"<< /Title (Invoice 42) /Author (apitest) /Producer (test) /CreationDate (D:20240501120000Z) /ModDate (yesterday) >>",
+		[]string{"Invoice 42", "Total: 119.00 EUR"},
+		[]string{"Thank you"},
+	)
+	jsonStr, err := Pdf2Json(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, exp := range map[string]string{
+		"title":           "Invoice 42",
+		"author":          "apitest",
+		"producer":        "test",
+		"creation_date":   "2024-05-01T12:00:00Z",
+		"mod_date":        "yesterday",
+		"page_count":      "2",
+		"pages.0.number":  "1",
+		"pages.0.text":    "Invoice 42\nTotal: 119.00 EUR",
+		"pages.0.lines.1": "Total: 119.00 EUR",
+		"pages.1.lines.#": "1",
+		"text":            "Invoice 42\nTotal: 119.00 EUR\nThank you",
+	} {
+		got := gjson.GetBytes(jsonStr, path).String()
+		if got != exp {
+			t.Errorf("%s: expected %q, got %q", path, exp, got)
+		}
+	}
+
+	_, err = Pdf2Json([]byte("%PDF-1.4\nno pdf"))
+	if err == nil {
+		t.Error("expected error for invalid pdf")
+	}
+}
+
+func TestPdfDate(t *testing.T) {
+	for raw, exp := range map[string]string{
+		"D:20240501120000+02'00'": "2024-05-01T12:00:00+02:00",
+		"D:20240501120000+02'00":  "2024-05-01T12:00:00+02:00",
+		"D:20240501120000Z":       "2024-05-01T12:00:00Z",
+		"D:20240501":              "2024-05-01T00:00:00Z",
+		"2024":                    "2024-01-01T00:00:00Z",
+		"":                        "",
+		"yesterday":               "yesterday",
+	} {
+		got := pdfDate(raw)
+		if got != exp {
+			t.Errorf("pdfDate(%q): expected %q, got %q", raw, exp, got)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	"golang.org/x/net/html"
+)
+
+// selectorSuffixRegex matches the optional suffix of a selector, which
+// defines what is extracted from the selected node
+var selectorSuffixRegex = regexp.MustCompile(`::(text|html|attr\(([^)]+)\))$`)
+
+// selector is a parsed selector of the "select" option of the response
+// format
+type selector struct {
+	expr    string
+	extract string // "text", "html" or "attr"
+	attr    string // name of the attribute for "attr"
+}
+
+func parseSelector(s string) (sel selector) {
+	sel = selector{
+		expr:    strings.TrimSpace(s),
+		extract: "text",
+	}
+	m := selectorSuffixRegex.FindStringSubmatch(sel.expr)
+	if m != nil {
+		sel.expr = strings.TrimSpace(strings.TrimSuffix(sel.expr, m[0]))
+		sel.extract = m[1]
+		if m[2] != "" {
+			sel.extract = "attr"
+			sel.attr = strings.TrimSpace(m[2])
+		}
+	}
+	return sel
+}
+
+// xpathValue evaluates the xpath expression. If the result is not a node set
+// (like "count(//li)"), it is returned as value, otherwise isNodeSet is true.
+func xpathValue(expr string, nav xpath.NodeNavigator) (value any, isNodeSet bool, err error) {
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid xpath %q: %w", expr, err)
+	}
+	switch v := compiled.Evaluate(nav).(type) {
+	case *xpath.NodeIterator:
+		return nil, true, nil
+	case float64, string, bool:
+		return v, false, nil
+	default:
+		return nil, false, fmt.Errorf("unsupported result %T of xpath %q", v, expr)
+	}
+}
+
+// selectHtml selects the first node matching the css selector or the xpath
+// expression. A selector which is valid css is used as css selector.
+func (sel selector) selectHtml(doc *html.Node) (value any, err error) {
+	var node *html.Node
+	cssSel, cssErr := cascadia.Parse(sel.expr)
+	if cssErr == nil {
+		node = cascadia.Query(doc, cssSel)
+	} else {
+		value, isNodeSet, err := xpathValue(sel.expr, htmlquery.CreateXPathNavigator(doc))
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a css selector (%w) nor an xpath: %w", sel.expr, cssErr, err)
+		}
+		if !isNodeSet {
+			return value, nil
+		}
+		node = htmlquery.FindOne(doc, sel.expr)
+	}
+	if node == nil {
+		return nil, nil
+	}
+
+	switch sel.extract {
+	case "html":
+		return htmlquery.OutputHTML(node, false), nil
+	case "attr":
+		for _, attr := range node.Attr {
+			if attr.Key == sel.attr {
+				return attr.Val, nil
+			}
+		}
+		return nil, nil
+	}
+	return strings.TrimSpace(htmlquery.InnerText(node)), nil
+}
+
+// selectXml selects the first node matching the xpath expression
+func (sel selector) selectXml(doc *xmlquery.Node) (value any, err error) {
+	value, isNodeSet, err := xpathValue(sel.expr, xmlquery.CreateXPathNavigator(doc))
+	if err != nil {
+		return nil, err
+	}
+	if !isNodeSet {
+		return value, nil
+	}
+	node := xmlquery.FindOne(doc, sel.expr)
+	if node == nil {
+		return nil, nil
+	}
+
+	switch sel.extract {
+	case "html":
+		return node.OutputXML(false), nil
+	case "attr":
+		for _, attr := range node.Attr {
+			if attr.Name.Local == sel.attr {
+				return attr.Value, nil
+			}
+		}
+		return nil, nil
+	}
+	return strings.TrimSpace(node.InnerText()), nil
+}
+
+// Select2Json parses the raw xml or html data and converts the values of the
+// selectors into a flat json object with the names of the selectors as keys.
+// The format is "xml", "xml2", "xhtml" or "html". Xml documents support
+// xpath, html and xhtml documents css selectors and xpath. The value is the
+// text of the first matching node, or null if no node matches.
+func Select2Json(rawData []byte, format string, selectors map[string]string) (jsonStr []byte, err error) {
+	var (
+		values  map[string]any
+		htmlDoc *html.Node
+		xmlDoc  *xmlquery.Node
+	)
+
+	switch format {
+	case "xml", "xml2":
+		xmlDoc, err = xmlquery.Parse(bytes.NewReader(xmlDeclarationRegex.ReplaceAll(rawData, []byte{})))
+		if err != nil {
+			return []byte{}, fmt.Errorf("could not parse xml: %w", err)
+		}
+	case "xhtml", "html":
+		htmlDoc, err = htmlquery.Parse(bytes.NewReader(rawData))
+		if err != nil {
+			return []byte{}, fmt.Errorf("could not parse html: %w", err)
+		}
+	default:
+		return []byte{}, fmt.Errorf("select is not supported for format %q", format)
+	}
+
+	values = map[string]any{}
+	for _, name := range slices.Sorted(maps.Keys(selectors)) {
+		sel := parseSelector(selectors[name])
+		if xmlDoc != nil {
+			values[name], err = sel.selectXml(xmlDoc)
+		} else {
+			values[name], err = sel.selectHtml(htmlDoc)
+		}
+		if err != nil {
+			return []byte{}, fmt.Errorf("select %q: %w", name, err)
+		}
+	}
+
+	jsonStr, err = jsutil.Marshal(values)
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not convert to json: %w", err)
+	}
+
+	return jsonStr, nil
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestSelect2JsonHtml(t *testing.T) {
+	raw := []byte(`<!DOCTYPE html>
+<html>
+<head><title> Login </title></head>
+<body>
+	<form id="login" action="/session">
+		<input type="hidden" name="csrf" value="abc123">
+		<p class="hint">Use your <b>email</b></p>
+	</form>
+	<ul><li>one</li><li>two</li><li>three</li></ul>
+</body>
+</html>`)
+
+	jsonStr, err := Select2Json(raw, "html", map[string]string{
+		"title":  "title",
+		"csrf":   `input[name="csrf"]::attr(value)`,
+		"action": "//form/@action",
+		"hint":   "#login .hint::html",
+		"second": "ul li:nth-child(2)",
+		"count":  "count(//li)",
+		"last":   "(//li)[last()]",
+		"none":   ".missing",
+		"noattr": "form::attr(method)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, exp := range map[string]string{
+		"title":  "Login",
+		"csrf":   "abc123",
+		"action": "/session",
+		"hint":   "Use your <b>email</b>",
+		"second": "two",
+		"count":  "3",
+		"last":   "three",
+		"none":   "",
+		"noattr": "",
+	} {
+		if got := gjson.Get(string(jsonStr), path).String(); got != exp {
+			t.Errorf("%s: expected %q, got %q", path, exp, got)
+		}
+	}
+	if gjson.Get(string(jsonStr), "none").Type != gjson.Null {
+		t.Errorf("expected null for a selector without match, got %s", jsonStr)
+	}
+
+	_, err = Select2Json(raw, "html", map[string]string{"invalid": "//li["})
+	if err == nil {
+		t.Errorf("expected error for invalid selector")
+	}
+}
+
+func TestSelect2JsonXml(t *testing.T) {
+	raw := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<feed>
+	<entry id="1"><title>First</title></entry>
+	<entry id="2"><title>Second <i>post</i></title></entry>
+</feed>`)
+
+	jsonStr, err := Select2Json(raw, "xml2", map[string]string{
+		"first":   "/feed/entry[1]/title",
+		"id":      "/feed/entry[2]::attr(id)",
+		"id2":     "/feed/entry[2]/@id",
+		"inner":   "/feed/entry[2]/title::html",
+		"entries": "count(//entry)",
+		"exists":  "boolean(//entry[@id='3'])",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, exp := range map[string]string{
+		"first":   "First",
+		"id":      "2",
+		"id2":     "2",
+		"inner":   "Second <i>post</i>",
+		"entries": "2",
+		"exists":  "false",
+	} {
+		if got := gjson.Get(string(jsonStr), path).String(); got != exp {
+			t.Errorf("%s: expected %q, got %q", path, exp, got)
+		}
+	}
+
+	_, err = Select2Json(raw, "xml", map[string]string{"css": "#feed .entry"})
+	if err == nil {
+		t.Errorf("expected error for css selector on xml")
+	}
+
+	_, err = Select2Json(raw, "json", map[string]string{"first": "/feed"})
+	if err == nil {
+		t.Errorf("expected error for unsupported format")
+	}
+}
@@ -2,19 +2,22 @@ package util
 
 import (
 	"bytes"
-	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/clbanning/mxj"
-	libcsv "github.com/programmfabrik/apitest/pkg/lib/csv"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pelletier/go-toml/v2"
 	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
 	"github.com/programmfabrik/golib"
-	"github.com/xuri/excelize/v2"
+	"github.com/vmihailenco/msgpack/v5"
 	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
 )
 
 var xmlDeclarationRegex = regexp.MustCompile(`<\?xml.*?\?>`)
@@ -98,56 +101,124 @@ func Xhtml2Json(rawXhtml []byte) (jsonStr []byte, err error) {
 	return jsonStr, nil
 }
 
-// Xlsx2Json parses the raw xlsx data and converts it into a json string.
-// Only the content is parsed, all formatting etc is discarded.
-// The result structure is the same as for CSV.
-func Xlsx2Json(rawXlsx []byte, sheetIdx int) (jsonStr []byte, err error) {
+// Yaml2Json parses the raw yaml data and converts it into a json string.
+// A stream with multiple documents is converted into an array of the documents.
+func Yaml2Json(rawYaml []byte) (jsonStr []byte, err error) {
 	var (
-		csvBuf bytes.Buffer
-		xlsx   *excelize.File
+		docs []any
+		data any
 	)
 
-	// parse xlsx raw data
-	xlsx, err = excelize.OpenReader(bytes.NewReader(rawXlsx))
+	decoder := yaml.NewDecoder(bytes.NewReader(rawYaml))
+	for {
+		var doc any
+		err = decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return []byte{}, fmt.Errorf("could not parse yaml: %w", err)
+		}
+		docs = append(docs, stringKeys(doc))
+	}
+
+	switch len(docs) {
+	case 0:
+		data = nil
+	case 1:
+		data = docs[0]
+	default:
+		data = docs
+	}
+
+	jsonStr, err = jsutil.Marshal(data)
 	if err != nil {
-		return []byte{}, fmt.Errorf("could not read raw xlsx data: %w", err)
+		return []byte{}, fmt.Errorf("could not convert to json: %w", err)
 	}
-	defer xlsx.Close()
 
-	// check if the requested sheet idx is valid
-	if sheetIdx < 0 || sheetIdx >= xlsx.SheetCount {
-		return []byte{}, fmt.Errorf("could not read xlsx sheet: idx %d invalid: expect idx between 0 and %d", sheetIdx, xlsx.SheetCount-1)
+	return jsonStr, nil
+}
+
+// stringKeys converts the keys of all maps into strings, yaml, msgpack and
+// cbor allow keys like numbers or booleans which json objects can not have
+func stringKeys(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, v2 := range t {
+			t[k] = stringKeys(v2)
+		}
+		return t
+	case map[any]any:
+		m := make(map[string]any, len(t))
+		for k, v2 := range t {
+			m[fmt.Sprint(k)] = stringKeys(v2)
+		}
+		return m
+	case []any:
+		for i, v2 := range t {
+			t[i] = stringKeys(v2)
+		}
+		return t
 	}
+	return v
+}
 
-	xlsxSheet := xlsx.GetSheetName(sheetIdx)
-	if xlsxSheet == "" {
-		return []byte{}, fmt.Errorf("could not parse xlsx: idx %d invalid: no sheets found", sheetIdx)
+// Toml2Json parses the raw toml data and converts it into a json string.
+// Dates and times are converted into strings.
+func Toml2Json(rawToml []byte) (jsonStr []byte, err error) {
+	var (
+		data map[string]any
+	)
+
+	err = toml.Unmarshal(rawToml, &data)
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not parse toml: %w", err)
 	}
 
-	// read xlsx xlsxRows
-	xlsxRows, err := xlsx.GetRows(xlsxSheet)
+	jsonStr, err = jsutil.Marshal(data)
 	if err != nil {
-		return []byte{}, fmt.Errorf("could not parse xlsx: %w", err)
+		return []byte{}, fmt.Errorf("could not convert to json: %w", err)
 	}
 
-	// built dummy csv to convert it into json
-	csvWriter := csv.NewWriter(&csvBuf)
-	csvWriter.Comma = ','
-	for _, xlsxRow := range xlsxRows {
-		err = csvWriter.Write(xlsxRow)
-		if err != nil {
-			return []byte{}, fmt.Errorf("could not convert xlsx into csv: %w", err)
-		}
+	return jsonStr, nil
+}
+
+// Msgpack2Json parses the raw MessagePack data and converts it into a json string
+func Msgpack2Json(rawMsgpack []byte) (jsonStr []byte, err error) {
+	var (
+		data any
+	)
+
+	decoder := msgpack.NewDecoder(bytes.NewReader(rawMsgpack))
+	// maps with keys other than strings would fail with the default decoder
+	decoder.SetMapDecoder(func(d *msgpack.Decoder) (any, error) {
+		return d.DecodeUntypedMap()
+	})
+	err = decoder.Decode(&data)
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not parse msgpack: %w", err)
 	}
-	csvWriter.Flush()
 
-	// parse dummy csv to convert it into json
-	csvData, err := libcsv.GenericCSVToMap(csvBuf.Bytes(), csvWriter.Comma)
+	jsonStr, err = jsutil.Marshal(stringKeys(data))
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not convert to json: %w", err)
+	}
+
+	return jsonStr, nil
+}
+
+// Cbor2Json parses the raw CBOR data and converts it into a json string
+func Cbor2Json(rawCbor []byte) (jsonStr []byte, err error) {
+	var (
+		data any
+	)
+
+	err = cbor.Unmarshal(rawCbor, &data)
 	if err != nil {
-		return []byte{}, fmt.Errorf("could not parse csv: %w", err)
+		return []byte{}, fmt.Errorf("could not parse cbor: %w", err)
 	}
 
-	jsonStr, err = jsutil.Marshal(csvData)
+	jsonStr, err = jsutil.Marshal(stringKeys(data))
 	if err != nil {
 		return []byte{}, fmt.Errorf("could not convert to json: %w", err)
 	}
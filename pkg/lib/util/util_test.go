@@ -3,7 +3,9 @@ package util
 import (
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 func TestRemoveFromJsonArray(t *testing.T) {
@@ -31,3 +33,92 @@ func TestRemoveFromJsonArray(t *testing.T) {
 		t.Errorf("Wrong slice removal: %s", output3)
 	}
 }
+
+func TestYaml2Json(t *testing.T) {
+	for _, tc := range []struct {
+		yaml string
+		json string
+	}{
+		{"a: 1\nb: [x, y]\n", `{"a":1,"b":["x","y"]}`},
+		{"1: one\ntrue: yes\n", `{"1":"one","true":"yes"}`},
+		{"---\na: 1\n---\na: 2\n", `[{"a":1},{"a":2}]`},
+		{"", `null`},
+	} {
+		jsonStr, err := Yaml2Json([]byte(tc.yaml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(jsonStr) != tc.json {
+			t.Errorf("Yaml2Json(%q): expected %s, got %s", tc.yaml, tc.json, jsonStr)
+		}
+	}
+
+	_, err := Yaml2Json([]byte("a: [1"))
+	if err == nil {
+		t.Error("expected error for invalid yaml")
+	}
+}
+
+func TestToml2Json(t *testing.T) {
+	jsonStr, err := Toml2Json([]byte("title = \"x\"\n\n[server]\nport = 8080\ndate = 2024-01-02\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"server":{"date":"2024-01-02","port":8080},"title":"x"}`
+	if string(jsonStr) != exp {
+		t.Errorf("expected %s, got %s", exp, jsonStr)
+	}
+
+	_, err = Toml2Json([]byte("a = "))
+	if err == nil {
+		t.Error("expected error for invalid toml")
+	}
+}
+
+func TestMsgpack2Json(t *testing.T) {
+	raw, err := msgpack.Marshal(map[any]any{
+		"name": "apitest",
+		"list": []any{1, "two", true, nil},
+		1:      map[string]any{"nested": 1.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonStr, err := Msgpack2Json(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"1":{"nested":1.5},"list":[1,"two",true,null],"name":"apitest"}`
+	if string(jsonStr) != exp {
+		t.Errorf("expected %s, got %s", exp, jsonStr)
+	}
+
+	_, err = Msgpack2Json([]byte{0x92, 0x01})
+	if err == nil {
+		t.Error("expected error for truncated msgpack")
+	}
+}
+
+func TestCbor2Json(t *testing.T) {
+	raw, err := cbor.Marshal(map[any]any{
+		"name": "apitest",
+		"list": []any{1, "two", true, nil},
+		true:   map[string]any{"nested": 1.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonStr, err := Cbor2Json(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"list":[1,"two",true,null],"name":"apitest","true":{"nested":1.5}}`
+	if string(jsonStr) != exp {
+		t.Errorf("expected %s, got %s", exp, jsonStr)
+	}
+
+	_, err = Cbor2Json([]byte{0x82, 0x01})
+	if err == nil {
+		t.Error("expected error for truncated cbor")
+	}
+}
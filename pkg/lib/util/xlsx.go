@@ -0,0 +1,344 @@
+package util
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+
+	libcsv "github.com/programmfabrik/apitest/pkg/lib/csv"
+	"github.com/programmfabrik/apitest/pkg/lib/jsutil"
+	"github.com/xuri/excelize/v2"
+)
+
+// XlsxOptions define which sheets are converted and how the cells are
+// converted by Xlsx2Json
+type XlsxOptions struct {
+	SheetIdx  int  // index of the sheet, ignored with AllSheets
+	AllSheets bool // convert all sheets into an object with the sheet names as keys
+	Typed     bool // numbers and booleans are json numbers and booleans, empty cells are null
+	Cells     bool // cells are objects with the typed value, the formatted text, the formula and the style
+}
+
+// xlsxCell is a cell of an xlsx sheet, if XlsxOptions.Cells is set
+type xlsxCell struct {
+	Value        any    `json:"value"`
+	Type         string `json:"type"` // "number", "bool", "string", "date", "error" or "empty"
+	Text         string `json:"text"` // value formatted with the number format, like it is shown in excel
+	Formula      string `json:"formula,omitempty"`
+	NumberFormat string `json:"number_format,omitempty"` // omitted for "General"
+	Bold         bool   `json:"bold,omitempty"`
+	Italic       bool   `json:"italic,omitempty"`
+	Merged       string `json:"merged,omitempty"` // range of the merged cells the cell belongs to, like "A1:C1"
+}
+
+// xlsxBuiltInNumFmts are the codes of the built-in number formats of the
+// Office Open XML standard, which are not stored in the file
+var xlsxBuiltInNumFmts = map[int]string{
+	1:  "0",
+	2:  "0.00",
+	3:  "#,##0",
+	4:  "#,##0.00",
+	9:  "0%",
+	10: "0.00%",
+	11: "0.00E+00",
+	12: "# ?/?",
+	13: "# ??/??",
+	14: "mm-dd-yy",
+	15: "d-mmm-yy",
+	16: "d-mmm",
+	17: "mmm-yy",
+	18: "h:mm AM/PM",
+	19: "h:mm:ss AM/PM",
+	20: "h:mm",
+	21: "h:mm:ss",
+	22: "m/d/yy h:mm",
+	37: "#,##0 ;(#,##0)",
+	38: "#,##0 ;[Red](#,##0)",
+	39: "#,##0.00;(#,##0.00)",
+	40: "#,##0.00;[Red](#,##0.00)",
+	45: "mm:ss",
+	46: "[h]:mm:ss",
+	47: "mmss.0",
+	48: "##0.0E+0",
+	49: "@",
+}
+
+// xlsxSheet converts the rows of a sheet
+type xlsxSheet struct {
+	xlsx   *excelize.File
+	name   string
+	opts   XlsxOptions
+	styles map[int]*excelize.Style // cache of the styles by id
+	merged map[string]string       // range of merged cells by cell name
+}
+
+// Xlsx2Json parses the raw xlsx data and converts it into a json string.
+// By default only the text content of the cells is parsed, all formatting
+// etc is discarded, and the result structure is the same as for CSV. The
+// options can add the types, formulas and styles of the cells.
+func Xlsx2Json(rawXlsx []byte, opts XlsxOptions) (jsonStr []byte, err error) {
+	var (
+		xlsx *excelize.File
+		data any
+	)
+
+	// parse xlsx raw data
+	xlsx, err = excelize.OpenReader(bytes.NewReader(rawXlsx))
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not read raw xlsx data: %w", err)
+	}
+	defer xlsx.Close()
+
+	if opts.AllSheets {
+		sheets := map[string]any{}
+		for _, name := range xlsx.GetSheetList() {
+			sheets[name], err = xlsxSheet{xlsx: xlsx, name: name, opts: opts}.toMap()
+			if err != nil {
+				return []byte{}, fmt.Errorf("sheet %q: %w", name, err)
+			}
+		}
+		data = sheets
+	} else {
+		// check if the requested sheet idx is valid
+		if opts.SheetIdx < 0 || opts.SheetIdx >= xlsx.SheetCount {
+			return []byte{}, fmt.Errorf("could not read xlsx sheet: idx %d invalid: expect idx between 0 and %d", opts.SheetIdx, xlsx.SheetCount-1)
+		}
+
+		name := xlsx.GetSheetName(opts.SheetIdx)
+		if name == "" {
+			return []byte{}, fmt.Errorf("could not parse xlsx: idx %d invalid: no sheets found", opts.SheetIdx)
+		}
+
+		data, err = xlsxSheet{xlsx: xlsx, name: name, opts: opts}.toMap()
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+
+	jsonStr, err = jsutil.Marshal(data)
+	if err != nil {
+		return []byte{}, fmt.Errorf("could not convert to json: %w", err)
+	}
+
+	return jsonStr, nil
+}
+
+// toMap converts the rows of the sheet into objects, the first row has the
+// names of the columns
+func (s xlsxSheet) toMap() (rows []map[string]any, err error) {
+	// read xlsx xlsxRows
+	xlsxRows, err := s.xlsx.GetRows(s.name)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse xlsx: %w", err)
+	}
+
+	// an empty sheet is only allowed if all sheets are converted
+	if isEmptyXlsxSheet(xlsxRows) {
+		if s.opts.AllSheets {
+			return []map[string]any{}, nil
+		}
+		return nil, fmt.Errorf("could not parse xlsx: sheet %q is empty", s.name)
+	}
+
+	if !s.opts.Typed && !s.opts.Cells {
+		return s.textRows(xlsxRows)
+	}
+	return s.typedRows(xlsxRows)
+}
+
+// textRows converts the text of the cells like a csv file
+func (s xlsxSheet) textRows(xlsxRows [][]string) (rows []map[string]any, err error) {
+	var (
+		csvBuf bytes.Buffer
+	)
+
+	// built dummy csv to convert it into json
+	csvWriter := csv.NewWriter(&csvBuf)
+	csvWriter.Comma = ','
+	for _, xlsxRow := range xlsxRows {
+		err = csvWriter.Write(xlsxRow)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert xlsx into csv: %w", err)
+		}
+	}
+	csvWriter.Flush()
+
+	// parse dummy csv to convert it into json
+	rows, err = libcsv.GenericCSVToMap(csvBuf.Bytes(), csvWriter.Comma)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse csv: %w", err)
+	}
+	return rows, nil
+}
+
+// typedRows converts the cells into typed values or cell objects. The rows
+// and columns are the same as for textRows: empty rows are skipped, the
+// first row has the names of the columns, and a row only has the columns up
+// to its last cell which is not empty.
+func (s xlsxSheet) typedRows(xlsxRows [][]string) (rows []map[string]any, err error) {
+	s.styles = map[int]*excelize.Style{}
+	s.merged = map[string]string{}
+	if s.opts.Cells {
+		err = s.loadMergedCells()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows = []map[string]any{}
+	var header []string
+	for rowIdx, xlsxRow := range xlsxRows {
+		if isEmptyXlsxRow(xlsxRow) {
+			continue
+		}
+		if header == nil {
+			header = []string{}
+			for _, name := range xlsxRow {
+				header = append(header, strings.TrimSpace(name))
+			}
+			continue
+		}
+		row := map[string]any{}
+		for colIdx, name := range header {
+			if colIdx >= len(xlsxRow) {
+				break
+			}
+			cellName, err := excelize.CoordinatesToCellName(colIdx+1, rowIdx+1)
+			if err != nil {
+				return nil, err
+			}
+			row[name], err = s.cell(cellName)
+			if err != nil {
+				return nil, fmt.Errorf("cell %s: %w", cellName, err)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// isEmptyXlsxRow returns true for a row without any text. A row with only
+// spaces is not empty, like a line with only spaces in a csv file.
+func isEmptyXlsxRow(xlsxRow []string) bool {
+	return strings.Join(xlsxRow, "") == ""
+}
+
+func isEmptyXlsxSheet(xlsxRows [][]string) bool {
+	for _, xlsxRow := range xlsxRows {
+		if !isEmptyXlsxRow(xlsxRow) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadMergedCells remembers the range of each merged cell
+func (s xlsxSheet) loadMergedCells() (err error) {
+	mergeCells, err := s.xlsx.GetMergeCells(s.name, true)
+	if err != nil {
+		return fmt.Errorf("could not read merged cells: %w", err)
+	}
+	for _, mc := range mergeCells {
+		startCol, startRow, err := excelize.CellNameToCoordinates(mc.GetStartAxis())
+		if err != nil {
+			return err
+		}
+		endCol, endRow, err := excelize.CellNameToCoordinates(mc.GetEndAxis())
+		if err != nil {
+			return err
+		}
+		for col := startCol; col <= endCol; col++ {
+			for row := startRow; row <= endRow; row++ {
+				cellName, err := excelize.CoordinatesToCellName(col, row)
+				if err != nil {
+					return err
+				}
+				s.merged[cellName] = mc.GetStartAxis() + ":" + mc.GetEndAxis()
+			}
+		}
+	}
+	return nil
+}
+
+// typedValue returns the value of the cell as json type
+func (s xlsxSheet) typedValue(cellName string) (value any, cellType string, err error) {
+	raw, err := s.xlsx.GetCellValue(s.name, cellName, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, "", err
+	}
+	ct, err := s.xlsx.GetCellType(s.name, cellName)
+	if err != nil {
+		return nil, "", err
+	}
+	switch ct {
+	case excelize.CellTypeBool:
+		return raw == "1" || strings.EqualFold(raw, "true"), "bool", nil
+	case excelize.CellTypeDate:
+		return raw, "date", nil
+	case excelize.CellTypeError:
+		return raw, "error", nil
+	case excelize.CellTypeFormula, excelize.CellTypeInlineString, excelize.CellTypeSharedString:
+		// "formula" is the type of string results of formulas
+		return raw, "string", nil
+	}
+	// numbers have no type or the type "n"
+	if raw == "" {
+		return nil, "empty", nil
+	}
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return raw, "string", nil
+	}
+	return n, "number", nil
+}
+
+// cell returns the typed value or, with XlsxOptions.Cells, the cell object
+func (s xlsxSheet) cell(cellName string) (cell any, err error) {
+	value, cellType, err := s.typedValue(cellName)
+	if err != nil {
+		return nil, err
+	}
+	if !s.opts.Cells {
+		return value, nil
+	}
+
+	c := xlsxCell{
+		Value:  value,
+		Type:   cellType,
+		Merged: s.merged[cellName],
+	}
+	c.Text, err = s.xlsx.GetCellValue(s.name, cellName)
+	if err != nil {
+		return nil, err
+	}
+	c.Formula, err = s.xlsx.GetCellFormula(s.name, cellName)
+	if err != nil {
+		return nil, err
+	}
+
+	styleID, err := s.xlsx.GetCellStyle(s.name, cellName)
+	if err != nil {
+		return nil, err
+	}
+	style, ok := s.styles[styleID]
+	if !ok {
+		style, err = s.xlsx.GetStyle(styleID)
+		if err != nil {
+			return nil, err
+		}
+		s.styles[styleID] = style
+	}
+	if style.CustomNumFmt != nil {
+		c.NumberFormat = *style.CustomNumFmt
+	} else {
+		c.NumberFormat = xlsxBuiltInNumFmts[style.NumFmt]
+	}
+	if style.Font != nil {
+		c.Bold = style.Font.Bold
+		c.Italic = style.Font.Italic
+	}
+
+	return c, nil
+}
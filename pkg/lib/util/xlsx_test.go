@@ -0,0 +1,198 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/xuri/excelize/v2"
+)
+
+// testXlsx builds a workbook with formulas, number formats and merged cells
+// in the first sheet, a second sheet and an empty third sheet
+func testXlsx(t *testing.T) []byte {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+
+	mustNotFail := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustNotFail(f.SetSheetName("Sheet1", "Orders"))
+	mustNotFail(f.SetSheetRow("Orders", "A1", &[]any{"item", "price", "paid", "note"}))
+	mustNotFail(f.SetSheetRow("Orders", "A2", &[]any{"apple", 1.5, true, "fruit"}))
+	mustNotFail(f.SetSheetRow("Orders", "A3", &[]any{"pear", 2, false}))
+	mustNotFail(f.SetSheetRow("Orders", "A5", &[]any{"total", 3.5}))
+	// excelize stores the result of a formula as string
+	mustNotFail(f.SetCellFormula("Orders", "B5", "SUM(B2:B3)"))
+	mustNotFail(f.MergeCell("Orders", "D2", "D3"))
+
+	numFmt := `0.00 "EUR"`
+	priceStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &numFmt})
+	mustNotFail(err)
+	mustNotFail(f.SetCellStyle("Orders", "B2", "B5", priceStyle))
+	boldStyle, err := f.NewStyle(&excelize.Style{NumFmt: 9, Font: &excelize.Font{Bold: true}})
+	mustNotFail(err)
+	mustNotFail(f.SetCellStyle("Orders", "A5", "A5", boldStyle))
+
+	_, err = f.NewSheet("Customers")
+	mustNotFail(err)
+	mustNotFail(f.SetSheetRow("Customers", "A1", &[]any{"name", "orders"}))
+	mustNotFail(f.SetSheetRow("Customers", "A2", &[]any{"fylr", 2}))
+
+	_, err = f.NewSheet("Empty")
+	mustNotFail(err)
+
+	buf, err := f.WriteToBuffer()
+	mustNotFail(err)
+	return buf.Bytes()
+}
+
+func TestXlsx2Json(t *testing.T) {
+	raw := testXlsx(t)
+
+	for _, tc := range []struct {
+		name string
+		opts XlsxOptions
+		exp  map[string]string
+	}{
+		{
+			name: "text",
+			opts: XlsxOptions{SheetIdx: 1},
+			exp: map[string]string{
+				"#":        "1",
+				"0.name":   "fylr",
+				"0.orders": "2",
+			},
+		},
+		{
+			name: "all sheets",
+			opts: XlsxOptions{AllSheets: true},
+			exp: map[string]string{
+				"Customers.0.orders": `"2"`,
+				"Empty":              "[]",
+				"Orders.#":           "3",
+				"Orders.0.price":     `"1.50 EUR"`,
+				"Orders.0.paid":      `"TRUE"`,
+			},
+		},
+		{
+			name: "typed",
+			opts: XlsxOptions{AllSheets: true, Typed: true},
+			exp: map[string]string{
+				"Customers.0.orders": "2",
+				"Empty":              "[]",
+				"Orders.#":           "3",
+				"Orders.0.price":     "1.5",
+				"Orders.0.paid":      "true",
+				"Orders.1.paid":      "false",
+				// the merged cell D3 has no value, like in the text
+				"Orders.1.note":  "",
+				"Orders.2.price": `"3.5"`,
+				"Orders.2.paid":  "",
+			},
+		},
+		{
+			name: "cells",
+			opts: XlsxOptions{Cells: true},
+			exp: map[string]string{
+				"0.price.value":         "1.5",
+				"0.price.type":          `"number"`,
+				"0.price.text":          `"1.50 EUR"`,
+				"0.price.number_format": `"0.00 \"EUR\""`,
+				"0.paid.type":           `"bool"`,
+				"0.note.merged":         `"D2:D3"`,
+				"1.note.merged":         "",
+				"1.item.merged":         "",
+				"2.item.bold":           "true",
+				"2.item.number_format":  `"0%"`,
+				"2.price.formula":       `"SUM(B2:B3)"`,
+				"2.price.type":          `"string"`,
+				"2.paid.value":          "",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonStr, err := Xlsx2Json(raw, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for path, exp := range tc.exp {
+				got := gjson.GetBytes(jsonStr, path).Raw
+				if tc.name == "text" {
+					got = gjson.GetBytes(jsonStr, path).String()
+				}
+				if got != exp {
+					t.Errorf("%s: expected %s, got %s", path, exp, got)
+				}
+			}
+		})
+	}
+
+	for _, opts := range []XlsxOptions{{SheetIdx: 2}, {SheetIdx: 2, Typed: true}} {
+		_, err := Xlsx2Json(raw, opts)
+		if err == nil {
+			t.Errorf("expected error for empty sheet")
+		}
+	}
+	_, err := Xlsx2Json(raw, XlsxOptions{SheetIdx: 3})
+	if err == nil {
+		t.Errorf("expected error for invalid sheet idx")
+	}
+}
+
+func TestXlsx2Json_SameRowsAndColumns(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	for cell, values := range map[string][]any{
+		"A2": {" name ", "count", "", "note "},
+		"A3": {"a", 1, true, "x"},
+		"A4": {" ", nil},
+		"A5": {"b", 2},
+		"A7": {"c", nil, nil, "y", "ignored"},
+	} {
+		err := f.SetSheetRow("Sheet1", cell, &values)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	textJSON, err := Xlsx2Json(buf.Bytes(), XlsxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []XlsxOptions{{Typed: true}, {Cells: true}} {
+		jsonStr, err := Xlsx2Json(buf.Bytes(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		textRows := gjson.ParseBytes(textJSON).Array()
+		rows := gjson.ParseBytes(jsonStr).Array()
+		if len(rows) != len(textRows) {
+			t.Fatalf("%+v: expected %d rows, got %d", opts, len(textRows), len(rows))
+		}
+		for idx := range rows {
+			var textKeys, keys []string
+			textRows[idx].ForEach(func(k, _ gjson.Result) bool {
+				textKeys = append(textKeys, k.String())
+				return true
+			})
+			rows[idx].ForEach(func(k, _ gjson.Result) bool {
+				keys = append(keys, k.String())
+				return true
+			})
+			if strings.Join(keys, ",") != strings.Join(textKeys, ",") {
+				t.Errorf("%+v: row %d: expected keys %v, got %v", opts, idx, textKeys, keys)
+			}
+		}
+	}
+}